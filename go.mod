@@ -2,11 +2,27 @@ module stock_trading_bot
 
 go 1.22
 
+require (
+	github.com/alpacahq/alpaca-trade-api-go/v3 v3.4.0
+	github.com/apache/arrow/go/v17 v17.0.0
+	go.etcd.io/bbolt v1.3.11
+)
+
 require (
 	cloud.google.com/go v0.114.0 // indirect
-	github.com/alpacahq/alpaca-trade-api-go/v3 v3.4.0 // indirect
+	github.com/goccy/go-json v0.10.3 // indirect
+	github.com/google/flatbuffers v24.3.25+incompatible // indirect
 	github.com/josharian/intern v1.0.0 // indirect
+	github.com/klauspost/compress v1.17.9 // indirect
+	github.com/klauspost/cpuid/v2 v2.2.8 // indirect
 	github.com/mailru/easyjson v0.7.7 // indirect
-	github.com/panjf2000/ants/v2 v2.9.1 // indirect
+	github.com/pierrec/lz4/v4 v4.1.21 // indirect
 	github.com/shopspring/decimal v1.4.0 // indirect
+	github.com/zeebo/xxh3 v1.0.2 // indirect
+	golang.org/x/exp v0.0.0-20240222234643-814bf88cf225 // indirect
+	golang.org/x/mod v0.18.0 // indirect
+	golang.org/x/sync v0.7.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/tools v0.22.0 // indirect
+	golang.org/x/xerrors v0.0.0-20231012003039-104605ab7028 // indirect
 )
@@ -2,37 +2,51 @@ package main
 
 import (
 	// "encoding/base64"
+	"context"
+	"flag"
 	"fmt"
-	"github.com/panjf2000/ants/v2"
 	"io"
 	"net/http"
 	"os"
 	"strconv"
-	"sync"
+	"strings"
 	"sync/atomic"
 	"time"
 )
 
 const (
-	baseURL   = "https://data.alpaca.markets/v2/stocks"
-	userAgent = "APCA-GO/v3.4.0"
+	baseURL    = "https://data.alpaca.markets/v2/stocks"
+	cryptoURL  = "https://data.alpaca.markets/v1beta3/crypto"
+	tradingURL = "https://paper-api.alpaca.markets"
+	userAgent  = "APCA-GO/v3.4.0"
 )
 
 // Client holds the configuration for the API client
 type Client struct {
 	BaseURL       string
+	CryptoURL     string
+	TradingURL    string
 	APIKey        string
 	APISecret     string
 	RetryAttempts int
 	RetryWait     int
 	RetryCodes    map[int]bool
+	ReplayLog     *ReplayLog
+	RateLimiter   *RateLimiter
 	httpClient    *http.Client
+	requestID     uint64
 }
 
 // NewClient creates a new API client
 func NewClient() *Client {
+	trading := os.Getenv("APCA_API_BASE_URL")
+	if trading == "" {
+		trading = tradingURL
+	}
 	return &Client{
 		BaseURL:       baseURL,
+		CryptoURL:     cryptoURL,
+		TradingURL:    trading,
 		APIKey:        os.Getenv("APCA_API_KEY_ID"),
 		APISecret:     os.Getenv("APCA_API_SECRET_KEY"),
 		RetryAttempts: 3,
@@ -51,10 +65,27 @@ func NewClient() *Client {
 // 	}
 // }
 
+// StatusError is returned for responses with an unexpected HTTP status
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("failed with status code %d", e.StatusCode)
+}
+
 // makeRequest makes an HTTP request handling retries
-func (c *Client) makeRequest(method, path string) (string, error) {
-	url := c.BaseURL + "/" + path
-	req, err := http.NewRequest(method, url, nil)
+func (c *Client) makeRequest(ctx context.Context, method, path string) (string, error) {
+	return c.makeRequestURL(ctx, method, c.BaseURL+"/"+path)
+}
+
+// makeRequestURL makes an HTTP request to an absolute URL handling retries.
+// Only 429 is retried here, as the one response that says when to try again;
+// the worker pool retries whole jobs on server errors. Every attempt is
+// recorded to the replay log when one is attached. Cancelling ctx aborts the
+// request and any wait before it.
+func (c *Client) makeRequestURL(ctx context.Context, method, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
 		return "", fmt.Errorf("error creating request: %w", err)
 	}
@@ -65,71 +96,149 @@ func (c *Client) makeRequest(method, path string) (string, error) {
 
 	// retryAttempts := 3
 	retryWait := c.RetryWait
+	id := atomic.AddUint64(&c.requestID, 1)
 
 	for i := 0; i <= c.RetryAttempts; i++ {
+		if err := c.RateLimiter.Wait(ctx); err != nil {
+			return "", err
+		}
+		started := time.Now()
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
+			c.ReplayLog.Record(req, id, i, started, 0, 0, err)
 			return "", fmt.Errorf("error executing request: %w", err)
 		}
-		defer resp.Body.Close()
+		c.RateLimiter.Observe(resp.Header)
 
 		if resp.StatusCode == http.StatusOK {
 			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			c.ReplayLog.Record(req, id, i, started, resp.StatusCode, int64(len(body)), err)
 			if err != nil {
 				return "", fmt.Errorf("error reading response: %w", err)
 			}
 			return string(body), nil
-		} else if resp.StatusCode == 429 {
+		}
+
+		// Drain the error body so its size ends up in the replay log
+		n, _ := io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		c.ReplayLog.Record(req, id, i, started, resp.StatusCode, n, nil)
+
+		if resp.StatusCode == 429 {
 			retryAfter := resp.Header.Get("Retry-After")
 			waitTime, parseErr := strconv.Atoi(retryAfter)
-			if parseErr == nil {
-				time.Sleep(time.Duration(waitTime) * time.Second)
-			} else {
+			wait := time.Duration(waitTime) * time.Second
+			if parseErr != nil {
 				// Exponential backoff if Retry-After is not available
-				time.Sleep(time.Duration(retryWait) * time.Second)
+				wait = time.Duration(retryWait) * time.Second
 				retryWait *= 2
 			}
+			if err := sleepContext(ctx, wait); err != nil {
+				return "", err
+			}
 		} else {
-			return "", fmt.Errorf("failed with status code %d", resp.StatusCode)
+			return "", &StatusError{StatusCode: resp.StatusCode}
 		}
 	}
-	return "", fmt.Errorf("retries exceeded ")
+	return "", fmt.Errorf("retries exceeded: %w", &StatusError{StatusCode: http.StatusTooManyRequests})
 }
 
-func fetchData(client *Client, path string, wg *sync.WaitGroup, calls *int32) {
-	defer wg.Done()
-	_, err := client.makeRequest("GET", path)
-	if err != nil {
-		fmt.Println("Error fetching data:", err)
-		return
-	}
-	atomic.AddInt32(calls, 1)
+// requestJob issues one request and discards the response
+type requestJob struct {
+	client *Client
+	path   string
 }
 
-func main() {
+func (j requestJob) Run() error {
+	_, err := j.client.makeRequest(context.Background(), "GET", j.path)
+	return err
+}
+
+func (j requestJob) String() string {
+	return j.path
+}
+
+// runBench hammers a single bars request for one minute and reports the call count
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	replayPath := fs.String("replay-log", "", "file to record every outgoing request to (off by default, as logging skews the throughput measured)")
+	rateLimit := fs.Int("rate-limit", 0, "requests per minute (0 for unlimited, as this measures raw throughput)")
+	workers := fs.Int("workers", 5, "number of concurrent workers")
+	fs.Parse(args)
+
 	client := NewClient()
-	var calls int32
+	if *rateLimit > 0 {
+		client.RateLimiter = NewRateLimiter(*rateLimit)
+	}
+	if *replayPath != "" {
+		replayLog, err := NewReplayLog(*replayPath)
+		if err != nil {
+			return err
+		}
+		defer replayLog.Close()
+		client.ReplayLog = replayLog
+	}
+
+	calls := 0
 	path := "bars?symbols=NVDA&timeframe=1Day&start=2016-01-03T00:00:00Z&end=2022-01-04T00:00:00Z&limit=1000&adjustment=all&feed=sip&sort=asc"
 
-	// Initialize the ants pool with the correct function signature
-	p, _ := ants.NewPoolWithFunc(5, func(i interface{}) {
-		fetchData(client, path, i.(*sync.WaitGroup), &calls)
-	})
-	defer p.Release()
-
-	var wg sync.WaitGroup
-	timer := time.NewTimer(time.Minute)
-	for {
-		select {
-		case <-timer.C:
-			wg.Wait()
-			fmt.Printf("Total API calls made in one minute: %d\n", calls)
+	// Feed the same request to the pool until the minute is up
+	queue := make(chan Job)
+	go func() {
+		defer close(queue)
+		timer := time.NewTimer(time.Minute)
+		for {
+			select {
+			case <-timer.C:
+				return
+			case queue <- requestJob{client: client, path: path}:
+			}
+			time.Sleep(10 * time.Millisecond) // Control the rate of queueing requests
+		}
+	}()
+
+	Pool{Workers: *workers}.Run(context.Background(), queue, func(job Job, err error, attempts int) {
+		if err != nil {
+			fmt.Println("Error fetching data:", err)
 			return
-		default:
-			wg.Add(1)
-			_ = p.Invoke(&wg)
 		}
-		time.Sleep(10 * time.Millisecond) // Control the rate of invoking go routines
+		calls++
+	})
+	fmt.Printf("Total API calls made in one minute: %d\n", calls)
+	return nil
+}
+
+func main() {
+	// The first argument selects the command, defaulting to the benchmark
+	cmd, args := "bench", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
 	}
 
+	var err error
+	switch cmd {
+	case "bench":
+		err = runBench(args)
+	case "run":
+		err = runFetch(args)
+	case "replay":
+		err = runReplay(args)
+	case "watchlist":
+		err = runWatchlist(args)
+	case "manifest":
+		err = runManifest(args)
+	case "plan":
+		err = runPlan(args)
+	case "snapshot":
+		err = runSnapshot(args)
+	case "latest":
+		err = runLatest(args)
+	default:
+		err = fmt.Errorf("unknown command %q (expected bench, run, plan, snapshot, latest, replay, watchlist or manifest)", cmd)
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"os"
+)
+
+// arrowBatchRows is the number of records buffered per Arrow record batch
+const arrowBatchRows = 64 * 1024
+
+// barSchema is the Arrow schema bars are written with. Files are
+// uncompressed Arrow IPC (Feather v2) so they can be memory-mapped by
+// pyarrow and Polars without copying.
+var barSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "timestamp", Type: &arrow.TimestampType{Unit: arrow.Nanosecond, TimeZone: "UTC"}},
+	{Name: "open", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "high", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "low", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "close", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "volume", Type: arrow.PrimitiveTypes.Uint64},
+	{Name: "trade_count", Type: arrow.PrimitiveTypes.Uint64},
+	{Name: "vwap", Type: arrow.PrimitiveTypes.Float64},
+}, nil)
+
+// appendBar adds one bar as a row of barSchema
+func appendBar(b *array.RecordBuilder, bar Bar) {
+	b.Field(0).(*array.TimestampBuilder).Append(arrow.Timestamp(bar.Timestamp.UnixNano()))
+	b.Field(1).(*array.Float64Builder).Append(bar.Open)
+	b.Field(2).(*array.Float64Builder).Append(bar.High)
+	b.Field(3).(*array.Float64Builder).Append(bar.Low)
+	b.Field(4).(*array.Float64Builder).Append(bar.Close)
+	b.Field(5).(*array.Uint64Builder).Append(bar.Volume)
+	b.Field(6).(*array.Uint64Builder).Append(bar.TradeCount)
+	b.Field(7).(*array.Float64Builder).Append(bar.VWAP)
+}
+
+// appendStrings adds a list of strings, such as condition codes, to a list column
+func appendStrings(b *array.ListBuilder, values []string) {
+	b.Append(true)
+	vb := b.ValueBuilder().(*array.StringBuilder)
+	for _, v := range values {
+		vb.Append(v)
+	}
+}
+
+// arrowWriter streams records into an Arrow IPC file in fixed-size record batches
+type arrowWriter[T any] struct {
+	file      *os.File
+	writer    *ipc.FileWriter
+	builder   *array.RecordBuilder
+	appendRow func(b *array.RecordBuilder, v T)
+	rows      int
+}
+
+// newArrowWriter creates the Arrow IPC file at location. appendRow adds one record to the builder of schema.
+func newArrowWriter[T any](location string, schema *arrow.Schema, appendRow func(b *array.RecordBuilder, v T)) (*arrowWriter[T], error) {
+	file, err := os.Create(location)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s: %w", location, err)
+	}
+
+	mem := memory.NewGoAllocator()
+	writer, err := ipc.NewFileWriter(file, ipc.WithSchema(schema), ipc.WithAllocator(mem))
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error creating Arrow writer: %w", err)
+	}
+	return &arrowWriter[T]{file: file, writer: writer, builder: array.NewRecordBuilder(mem, schema), appendRow: appendRow}, nil
+}
+
+// Write buffers one record, flushing a record batch when it is full
+func (w *arrowWriter[T]) Write(v T) error {
+	w.appendRow(w.builder, v)
+	if w.rows++; w.rows >= arrowBatchRows {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered records as one record batch
+func (w *arrowWriter[T]) flush() error {
+	if w.rows == 0 {
+		return nil
+	}
+	rec := w.builder.NewRecord()
+	defer rec.Release()
+	w.rows = 0
+	if err := w.writer.Write(rec); err != nil {
+		return fmt.Errorf("error writing Arrow record batch: %w", err)
+	}
+	return nil
+}
+
+// Close flushes the last batch, writes the footer and closes the file
+func (w *arrowWriter[T]) Close() error {
+	defer w.builder.Release()
+	err := w.flush()
+	if closeErr := w.writer.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"time"
+)
+
+// Bar is a single OHLCV bar as returned by the bars endpoint
+type Bar struct {
+	Timestamp  time.Time `json:"t"`
+	Open       float64   `json:"o"`
+	High       float64   `json:"h"`
+	Low        float64   `json:"l"`
+	Close      float64   `json:"c"`
+	Volume     uint64    `json:"v"`
+	TradeCount uint64    `json:"n"`
+	VWAP       float64   `json:"vw"`
+}
+
+// barKind fetches bars from the multi-symbol bars endpoint
+var barKind = dataKind[Bar]{
+	endpoint:       "bars",
+	columns:        barColumns,
+	defaultColumns: defaultBarColumns,
+	schema:         barSchema,
+	appendRow:      appendBar,
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// marketTimezone is the timezone the calendar's dates and times are given in
+const marketTimezone = "America/New_York"
+
+// calendarDay is one trading day of the market calendar
+type calendarDay struct {
+	Date         string `json:"date"`
+	Open         string `json:"open"`
+	Close        string `json:"close"`
+	SessionOpen  string `json:"session_open"`
+	SessionClose string `json:"session_close"`
+}
+
+// getCalendar lists the trading days between the dates of start and end
+func (c *Client) getCalendar(ctx context.Context, start, end time.Time) ([]calendarDay, error) {
+	params := url.Values{}
+	params.Set("start", start.Format("2006-01-02"))
+	params.Set("end", end.Format("2006-01-02"))
+	body, err := c.makeRequestURL(ctx, "GET", c.TradingURL+"/v2/calendar?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	var days []calendarDay
+	if err := json.Unmarshal([]byte(body), &days); err != nil {
+		return nil, fmt.Errorf("error decoding calendar: %w", err)
+	}
+	return days, nil
+}
+
+// marketSessions returns the trading sessions overlapping w, in order. Intraday
+// timeframes use the extended hours session, while daily and longer bars are
+// stamped at midnight and so take the whole trading day.
+func (c *Client) marketSessions(ctx context.Context, w window, barLength time.Duration) ([]window, error) {
+	loc, err := time.LoadLocation(marketTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("error loading market timezone: %w", err)
+	}
+	days, err := c.getCalendar(ctx, w.Start.In(loc), w.End.In(loc))
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]window, 0, len(days))
+	for _, d := range days {
+		s, err := d.session(loc, barLength >= 24*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+// session returns the trading window of the day, or the whole day when wholeDay is set
+func (d calendarDay) session(loc *time.Location, wholeDay bool) (window, error) {
+	date, err := time.ParseInLocation("2006-01-02", d.Date, loc)
+	if err != nil {
+		return window{}, fmt.Errorf("invalid calendar date %q: %w", d.Date, err)
+	}
+	if wholeDay {
+		return window{Start: date, End: date.AddDate(0, 0, 1)}, nil
+	}
+
+	// Older calendar entries only carry the regular hours
+	opens, closes, layout := d.SessionOpen, d.SessionClose, "1504"
+	if opens == "" || closes == "" {
+		opens, closes, layout = d.Open, d.Close, "15:04"
+	}
+	o, err := time.Parse(layout, opens)
+	if err != nil {
+		return window{}, fmt.Errorf("invalid calendar open %q on %s: %w", opens, d.Date, err)
+	}
+	c, err := time.Parse(layout, closes)
+	if err != nil {
+		return window{}, fmt.Errorf("invalid calendar close %q on %s: %w", closes, d.Date, err)
+	}
+	return window{
+		Start: time.Date(date.Year(), date.Month(), date.Day(), o.Hour(), o.Minute(), 0, 0, loc),
+		End:   time.Date(date.Year(), date.Month(), date.Day(), c.Hour(), c.Minute(), 0, 0, loc),
+	}, nil
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultConfigPath is where the fetch commands look for their config
+const defaultConfigPath = "./config/fetch.json"
+
+// defaultWatchlistCache is where synced watchlists are stored when the config does not say
+const defaultWatchlistCache = "./config/watchlists.json"
+
+// Config is the fetcher configuration file
+type Config struct {
+	Profiles map[string]Profile `json:"profiles"`
+	// WatchlistCache is the file synced Alpaca watchlists are stored in
+	WatchlistCache string `json:"watchlist_cache"`
+	// RateLimit is the request budget per minute shared by a run.
+	// Zero uses the free plan's 200 and a negative value disables limiting.
+	RateLimit int `json:"rate_limit"`
+	// Manifest is the database recording which windows were downloaded
+	Manifest string `json:"manifest"`
+}
+
+// Profile bundles the parameters of a reusable fetch job
+type Profile struct {
+	Symbols []string `json:"symbols"`
+	// SymbolsFile lists further symbols, one per line or comma separated
+	SymbolsFile string `json:"symbols_file"`
+	// Watchlist names an Alpaca watchlist whose symbols are fetched in addition to Symbols
+	Watchlist string `json:"watchlist"`
+	// Class is the asset class: stocks (default) or crypto
+	Class string `json:"class"`
+	// Location is the crypto venue, us (default) being Alpaca's own
+	Location string `json:"location"`
+	// Data is what is fetched: bars (default), quotes or trades. Crypto has bars and trades.
+	Data string `json:"data"`
+	// Timeframe and Adjustment only apply to bars
+	Timeframe  string `json:"timeframe"`
+	Feed       string `json:"feed"`
+	Adjustment string `json:"adjustment"`
+	// Start and End are RFC-3339 timestamps or dates, End being exclusive. An empty End means now.
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// Lookback is a duration before End used when Start is empty
+	Lookback string `json:"lookback"`
+	// Output is the directory the fetched data is written to
+	Output string `json:"output"`
+	// Format is the output file format: json (default), arrow or csv
+	Format string `json:"format"`
+	// Columns selects and orders the CSV columns. Empty writes all of them.
+	Columns []string `json:"columns"`
+	// TimeFormat is the CSV timestamp style: rfc3339 (default), epoch, epoch_ms or epoch_ns
+	TimeFormat string `json:"time_format"`
+	// Schedule is the interval between runs. An empty schedule runs once.
+	Schedule string `json:"schedule"`
+	// BatchSize is the number of symbols requested together from the multi-symbol endpoint
+	BatchSize int `json:"batch_size"`
+	// Chunk splits the range into jobs of this duration. Empty fetches bars as one
+	// chunk and quotes and trades a day at a time.
+	Chunk string `json:"chunk"`
+	// Workers is the number of jobs fetched concurrently
+	Workers int `json:"workers"`
+	// Retries is how many times a failed job is retried. A negative value disables retries.
+	Retries int `json:"retries"`
+	// CheckpointPages is how many pages a job writes before saving its progress.
+	// Each checkpoint starts a new part file. A negative value disables checkpoints.
+	CheckpointPages int `json:"checkpoint_pages"`
+}
+
+const (
+	// defaultBatchSize keeps multi-symbol request URLs comfortably short
+	defaultBatchSize = 100
+	defaultWorkers   = 4
+	defaultRetries   = 3
+	// defaultCheckpointPages makes parts of up to half a million bars
+	defaultCheckpointPages = 50
+	// defaultTickChunk keeps a job of quotes or trades for a liquid symbol to a few files
+	defaultTickChunk = 24 * time.Hour
+)
+
+// loadConfig reads and parses the config file at path
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("error parsing config %s: %w", path, err)
+	}
+	cfg.applyDefaults()
+	return &cfg, nil
+}
+
+// loadConfigOrDefault is loadConfig, falling back to the defaults when there is no config file
+func loadConfigOrDefault(path string) (*Config, error) {
+	cfg, err := loadConfig(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		cfg = &Config{}
+		cfg.applyDefaults()
+		return cfg, nil
+	}
+	return cfg, err
+}
+
+// applyDefaults fills in the settings left empty
+func (cfg *Config) applyDefaults() {
+	if cfg.WatchlistCache == "" {
+		cfg.WatchlistCache = defaultWatchlistCache
+	}
+	if cfg.RateLimit == 0 {
+		cfg.RateLimit = defaultRateLimit
+	}
+	if cfg.Manifest == "" {
+		cfg.Manifest = defaultManifestPath
+	}
+}
+
+// profile looks up a profile by name
+func (cfg *Config) profile(name string) (Profile, error) {
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(cfg.Profiles))
+		for n := range cfg.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Profile{}, fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return p, nil
+}
+
+// batchSize returns the configured batch size or the default
+func (p Profile) batchSize() int {
+	if p.BatchSize == 0 {
+		return defaultBatchSize
+	}
+	return p.BatchSize
+}
+
+// outputSpec returns the output settings of the profile
+func (p Profile) outputSpec() outputSpec {
+	return outputSpec{Data: p.request(window{}).kindName(), Format: p.Format, Columns: p.Columns, TimeStyle: p.TimeFormat}
+}
+
+// request returns the profile's request for the window w
+func (p Profile) request(w window) FetchRequest {
+	return FetchRequest{
+		Class:      p.Class,
+		Location:   p.Location,
+		Data:       p.Data,
+		Timeframe:  p.Timeframe,
+		Feed:       p.Feed,
+		Adjustment: p.Adjustment,
+		Start:      w.Start,
+		End:        w.End,
+	}
+}
+
+// barLength returns the length of one bar, or zero for data that is not aggregated
+func (p Profile) barLength() (time.Duration, error) {
+	if p.request(window{}).data() != dataBars {
+		return 0, nil
+	}
+	return timeframeDuration(p.Timeframe)
+}
+
+// workers returns the configured worker count or the default
+func (p Profile) workers() int {
+	if p.Workers == 0 {
+		return defaultWorkers
+	}
+	return p.Workers
+}
+
+// retries returns the configured retry count, or zero when retries are disabled
+func (p Profile) retries() int {
+	switch {
+	case p.Retries == 0:
+		return defaultRetries
+	case p.Retries < 0:
+		return 0
+	}
+	return p.Retries
+}
+
+// checkpointPages returns the pages between checkpoints, or zero when they are disabled
+func (p Profile) checkpointPages() int {
+	switch {
+	case p.CheckpointPages == 0:
+		return defaultCheckpointPages
+	case p.CheckpointPages < 0:
+		return 0
+	}
+	return p.CheckpointPages
+}
+
+// chunk parses the chunk duration, returning zero when the range is not split.
+// Quotes and trades are split by day unless told otherwise, since a single
+// liquid symbol can trade millions of times a day.
+func (p Profile) chunk() (time.Duration, error) {
+	if p.Chunk == "" {
+		if p.request(window{}).data() != dataBars {
+			return defaultTickChunk, nil
+		}
+		return 0, nil
+	}
+	d, err := time.ParseDuration(p.Chunk)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid chunk %q: expected a positive duration", p.Chunk)
+	}
+	return d, nil
+}
+
+// splitRange cuts w into consecutive chunks of at most size, each ending where the next begins
+func splitRange(w window, size time.Duration) []window {
+	if size <= 0 {
+		return []window{w}
+	}
+	var chunks []window
+	for from := w.Start; from.Before(w.End); from = from.Add(size) {
+		to := from.Add(size)
+		if to.After(w.End) {
+			to = w.End
+		}
+		chunks = append(chunks, window{Start: from, End: to})
+	}
+	return chunks
+}
+
+// timeframeDuration returns the length of one bar of a timeframe such as 15Min or 1Day
+func timeframeDuration(timeframe string) (time.Duration, error) {
+	i := strings.IndexFunc(timeframe, func(r rune) bool { return r < '0' || r > '9' })
+	if i <= 0 {
+		return 0, fmt.Errorf("invalid timeframe %q", timeframe)
+	}
+	n, err := strconv.Atoi(timeframe[:i])
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeframe %q", timeframe)
+	}
+
+	var unit time.Duration
+	switch timeframe[i:] {
+	case "Min", "T":
+		unit = time.Minute
+	case "Hour", "H":
+		unit = time.Hour
+	case "Day", "D":
+		unit = 24 * time.Hour
+	case "Week", "W":
+		unit = 7 * 24 * time.Hour
+	case "Month", "M":
+		unit = 31 * 24 * time.Hour
+	default:
+		return 0, fmt.Errorf("invalid timeframe %q: unknown unit %q", timeframe, timeframe[i:])
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// resolveSymbols returns the profile's symbols merged with those of its symbols
+// file and watchlist, de-duplicated in order of appearance
+func resolveSymbols(ctx context.Context, client *Client, cfg *Config, p Profile) ([]string, error) {
+	all := append([]string{}, p.Symbols...)
+	if p.SymbolsFile != "" {
+		listed, err := readSymbolsFile(p.SymbolsFile)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, listed...)
+	}
+	if p.Watchlist != "" {
+		listed, err := watchlistSymbols(ctx, client, cfg, p.Watchlist)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, listed...)
+	}
+
+	seen := make(map[string]bool, len(all))
+	var symbols []string
+	for _, s := range all {
+		if !seen[s] {
+			seen[s] = true
+			symbols = append(symbols, s)
+		}
+	}
+	if len(symbols) == 0 {
+		return nil, fmt.Errorf("no symbols to fetch")
+	}
+	return symbols, nil
+}
+
+// readSymbolsFile reads symbols separated by newlines or commas, skipping # comments
+func readSymbolsFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading symbols file: %w", err)
+	}
+
+	var symbols []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		symbols = append(symbols, splitSymbols(line)...)
+	}
+	return symbols, nil
+}
+
+// splitSymbols splits a comma separated list of symbols, upper-casing them
+func splitSymbols(list string) []string {
+	symbols := splitList(list)
+	for i, s := range symbols {
+		symbols[i] = strings.ToUpper(s)
+	}
+	return symbols
+}
+
+// splitList splits a comma separated list, trimming entries and dropping blanks
+func splitList(list string) []string {
+	var items []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	return items
+}
+
+// validate checks that the profile can be run
+func (p Profile) validate() error {
+	if len(p.Symbols) == 0 && p.SymbolsFile == "" && p.Watchlist == "" {
+		return fmt.Errorf("profile has no symbols, symbols file or watchlist")
+	}
+	if c := p.request(window{}).class(); c != classStocks && c != classCrypto {
+		return fmt.Errorf("unknown class %q (expected stocks or crypto)", p.Class)
+	}
+	if p.BatchSize < 0 || p.Workers < 0 {
+		return fmt.Errorf("batch size and workers must not be negative")
+	}
+	if _, err := p.chunk(); err != nil {
+		return err
+	}
+	if err := p.outputSpec().validate(); err != nil {
+		return err
+	}
+	if _, err := p.barLength(); err != nil {
+		return err
+	}
+	if p.Output == "" {
+		return fmt.Errorf("profile has no output")
+	}
+	if p.Start == "" && p.Lookback == "" {
+		return fmt.Errorf("profile needs a start or a lookback")
+	}
+	if _, err := p.interval(); err != nil {
+		return err
+	}
+	_, _, err := p.window(time.Now())
+	return err
+}
+
+// interval parses the schedule, returning zero for one-off profiles
+func (p Profile) interval() (time.Duration, error) {
+	if p.Schedule == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(p.Schedule)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schedule %q: %w", p.Schedule, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("schedule must be positive, got %q", p.Schedule)
+	}
+	return d, nil
+}
+
+// window resolves the time range of a run started at now
+func (p Profile) window(now time.Time) (time.Time, time.Time, error) {
+	end := now
+	if p.End != "" {
+		t, err := parseTime(p.End)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		end = t
+	}
+
+	if p.Start != "" {
+		start, err := parseTime(p.Start)
+		return start, end, err
+	}
+	lookback, err := time.ParseDuration(p.Lookback)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid lookback %q: %w", p.Lookback, err)
+	}
+	return end.Add(-lookback), end, nil
+}
+
+// parseTime accepts an RFC-3339 timestamp or a plain date
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: expected RFC-3339 or YYYY-MM-DD", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitRange(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name string
+		w    window
+		size time.Duration
+		want []window
+	}{
+		{
+			name: "no chunking",
+			w:    span(0, 3*day),
+			want: []window{span(0, 3*day)},
+		},
+		{
+			name: "shorter than a chunk",
+			w:    span(0, time.Hour),
+			size: day,
+			want: []window{span(0, time.Hour)},
+		},
+		{
+			name: "exact multiple",
+			w:    span(0, 2*day),
+			size: day,
+			want: []window{span(0, day), span(day, 2*day)},
+		},
+		{
+			name: "partial last chunk",
+			w:    span(0, 2*day+time.Hour),
+			size: day,
+			want: []window{span(0, day), span(day, 2*day), span(2*day, 2*day+time.Hour)},
+		},
+		{
+			name: "sub-second end",
+			w:    span(0, day+500*time.Millisecond),
+			size: day,
+			want: []window{span(0, day), span(day, day+500*time.Millisecond)},
+		},
+		{
+			name: "sub-second start",
+			w:    span(250*time.Millisecond, day),
+			size: 12 * time.Hour,
+			want: []window{span(250*time.Millisecond, 12*time.Hour+250*time.Millisecond), span(12*time.Hour+250*time.Millisecond, day)},
+		},
+		{
+			name: "empty",
+			w:    span(time.Hour, time.Hour),
+			size: day,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitRange(tt.w, tt.size); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitRange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeframeDuration(t *testing.T) {
+	tests := []struct {
+		timeframe string
+		want      time.Duration
+		wantErr   bool
+	}{
+		{timeframe: "1Min", want: time.Minute},
+		{timeframe: "15T", want: 15 * time.Minute},
+		{timeframe: "4Hour", want: 4 * time.Hour},
+		{timeframe: "1H", want: time.Hour},
+		{timeframe: "1Day", want: 24 * time.Hour},
+		{timeframe: "1D", want: 24 * time.Hour},
+		{timeframe: "1Week", want: 7 * 24 * time.Hour},
+		{timeframe: "3Month", want: 3 * 31 * 24 * time.Hour},
+		{timeframe: "", wantErr: true},
+		{timeframe: "Min", wantErr: true},
+		{timeframe: "5", wantErr: true},
+		{timeframe: "5Sec", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.timeframe, func(t *testing.T) {
+			got, err := timeframeDuration(tt.timeframe)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("timeframeDuration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("timeframeDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
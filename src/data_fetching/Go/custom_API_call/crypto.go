@@ -0,0 +1,129 @@
+package main
+
+import (
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"strconv"
+	"time"
+)
+
+// CryptoBar is a single crypto bar. Unlike stock bars the volume is fractional.
+type CryptoBar struct {
+	Timestamp  time.Time `json:"t"`
+	Open       float64   `json:"o"`
+	High       float64   `json:"h"`
+	Low        float64   `json:"l"`
+	Close      float64   `json:"c"`
+	Volume     float64   `json:"v"`
+	TradeCount uint64    `json:"n"`
+	VWAP       float64   `json:"vw"`
+	// Venue is the location the bar was fetched from, filled in by the fetcher
+	Venue string `json:"venue"`
+}
+
+// CryptoTrade is a single crypto trade
+type CryptoTrade struct {
+	Timestamp time.Time `json:"t"`
+	Price     float64   `json:"p"`
+	Size      float64   `json:"s"`
+	// TakerSide is B for a buy and S for a sell
+	TakerSide string `json:"tks"`
+	ID        int64  `json:"i"`
+	// Venue is the location the trade was fetched from, filled in by the fetcher
+	Venue string `json:"venue"`
+}
+
+// cryptoBarKind fetches bars from the crypto bars endpoint
+var cryptoBarKind = dataKind[CryptoBar]{
+	endpoint:       "bars",
+	columns:        cryptoBarColumns,
+	defaultColumns: defaultCryptoBarColumns,
+	schema:         cryptoBarSchema,
+	appendRow:      appendCryptoBar,
+	setVenue:       func(b *CryptoBar, venue string) { b.Venue = venue },
+}
+
+// cryptoTradeKind fetches trades from the crypto trades endpoint
+var cryptoTradeKind = dataKind[CryptoTrade]{
+	endpoint:       "trades",
+	columns:        cryptoTradeColumns,
+	defaultColumns: defaultCryptoTradeColumns,
+	schema:         cryptoTradeSchema,
+	appendRow:      appendCryptoTrade,
+	setVenue:       func(t *CryptoTrade, venue string) { t.Venue = venue },
+}
+
+// cryptoBarColumns are the CSV columns available for crypto bars
+var cryptoBarColumns = map[string]csvColumn[CryptoBar]{
+	"timestamp":   func(b CryptoBar, style string) string { return formatTimestamp(b.Timestamp, style) },
+	"venue":       func(b CryptoBar, _ string) string { return b.Venue },
+	"open":        func(b CryptoBar, _ string) string { return formatFloat(b.Open) },
+	"high":        func(b CryptoBar, _ string) string { return formatFloat(b.High) },
+	"low":         func(b CryptoBar, _ string) string { return formatFloat(b.Low) },
+	"close":       func(b CryptoBar, _ string) string { return formatFloat(b.Close) },
+	"volume":      func(b CryptoBar, _ string) string { return formatFloat(b.Volume) },
+	"trade_count": func(b CryptoBar, _ string) string { return strconv.FormatUint(b.TradeCount, 10) },
+	"vwap":        func(b CryptoBar, _ string) string { return formatFloat(b.VWAP) },
+}
+
+// defaultCryptoBarColumns is the column order used when none is configured
+var defaultCryptoBarColumns = []string{"timestamp", "venue", "open", "high", "low", "close", "volume", "trade_count", "vwap"}
+
+// cryptoTradeColumns are the CSV columns available for crypto trades
+var cryptoTradeColumns = map[string]csvColumn[CryptoTrade]{
+	"timestamp":  func(t CryptoTrade, style string) string { return formatTimestamp(t.Timestamp, style) },
+	"venue":      func(t CryptoTrade, _ string) string { return t.Venue },
+	"price":      func(t CryptoTrade, _ string) string { return formatFloat(t.Price) },
+	"size":       func(t CryptoTrade, _ string) string { return formatFloat(t.Size) },
+	"taker_side": func(t CryptoTrade, _ string) string { return t.TakerSide },
+	"id":         func(t CryptoTrade, _ string) string { return strconv.FormatInt(t.ID, 10) },
+}
+
+// defaultCryptoTradeColumns is the column order used when none is configured
+var defaultCryptoTradeColumns = []string{"timestamp", "venue", "price", "size", "taker_side", "id"}
+
+// cryptoBarSchema is the Arrow schema crypto bars are written with
+var cryptoBarSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "timestamp", Type: &arrow.TimestampType{Unit: arrow.Nanosecond, TimeZone: "UTC"}},
+	{Name: "venue", Type: arrow.BinaryTypes.String},
+	{Name: "open", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "high", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "low", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "close", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "volume", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "trade_count", Type: arrow.PrimitiveTypes.Uint64},
+	{Name: "vwap", Type: arrow.PrimitiveTypes.Float64},
+}, nil)
+
+// cryptoTradeSchema is the Arrow schema crypto trades are written with
+var cryptoTradeSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "timestamp", Type: &arrow.TimestampType{Unit: arrow.Nanosecond, TimeZone: "UTC"}},
+	{Name: "venue", Type: arrow.BinaryTypes.String},
+	{Name: "price", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "size", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "taker_side", Type: arrow.BinaryTypes.String},
+	{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+}, nil)
+
+// appendCryptoBar adds one crypto bar as a row of cryptoBarSchema
+func appendCryptoBar(b *array.RecordBuilder, bar CryptoBar) {
+	b.Field(0).(*array.TimestampBuilder).Append(arrow.Timestamp(bar.Timestamp.UnixNano()))
+	b.Field(1).(*array.StringBuilder).Append(bar.Venue)
+	b.Field(2).(*array.Float64Builder).Append(bar.Open)
+	b.Field(3).(*array.Float64Builder).Append(bar.High)
+	b.Field(4).(*array.Float64Builder).Append(bar.Low)
+	b.Field(5).(*array.Float64Builder).Append(bar.Close)
+	b.Field(6).(*array.Float64Builder).Append(bar.Volume)
+	b.Field(7).(*array.Uint64Builder).Append(bar.TradeCount)
+	b.Field(8).(*array.Float64Builder).Append(bar.VWAP)
+}
+
+// appendCryptoTrade adds one crypto trade as a row of cryptoTradeSchema
+func appendCryptoTrade(b *array.RecordBuilder, t CryptoTrade) {
+	b.Field(0).(*array.TimestampBuilder).Append(arrow.Timestamp(t.Timestamp.UnixNano()))
+	b.Field(1).(*array.StringBuilder).Append(t.Venue)
+	b.Field(2).(*array.Float64Builder).Append(t.Price)
+	b.Field(3).(*array.Float64Builder).Append(t.Size)
+	b.Field(4).(*array.StringBuilder).Append(t.TakerSide)
+	b.Field(5).(*array.Int64Builder).Append(t.ID)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timestamp styles for CSV output
+const (
+	timeRFC3339 = "rfc3339"
+	timeEpoch   = "epoch"
+	timeEpochMS = "epoch_ms"
+	timeEpochNS = "epoch_ns"
+)
+
+// checkTimeStyle validates a CSV timestamp style
+func checkTimeStyle(style string) error {
+	switch style {
+	case "", timeRFC3339, timeEpoch, timeEpochMS, timeEpochNS:
+		return nil
+	}
+	return fmt.Errorf("unknown time format %q (expected rfc3339, epoch, epoch_ms or epoch_ns)", style)
+}
+
+// formatTimestamp renders t in the given style, defaulting to RFC-3339
+func formatTimestamp(t time.Time, style string) string {
+	switch style {
+	case timeEpoch:
+		return strconv.FormatInt(t.Unix(), 10)
+	case timeEpochMS:
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	case timeEpochNS:
+		return strconv.FormatInt(t.UnixNano(), 10)
+	default:
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// formatConditions joins condition codes into one field. Codes can be a
+// space, so they are separated by commas and the field ends up quoted.
+func formatConditions(conditions []string) string {
+	return strings.Join(conditions, ",")
+}
+
+// csvColumn renders one field of a record
+type csvColumn[T any] func(v T, timeStyle string) string
+
+// barColumns are the CSV columns available for bars
+var barColumns = map[string]csvColumn[Bar]{
+	"timestamp":   func(b Bar, style string) string { return formatTimestamp(b.Timestamp, style) },
+	"open":        func(b Bar, _ string) string { return formatFloat(b.Open) },
+	"high":        func(b Bar, _ string) string { return formatFloat(b.High) },
+	"low":         func(b Bar, _ string) string { return formatFloat(b.Low) },
+	"close":       func(b Bar, _ string) string { return formatFloat(b.Close) },
+	"volume":      func(b Bar, _ string) string { return strconv.FormatUint(b.Volume, 10) },
+	"trade_count": func(b Bar, _ string) string { return strconv.FormatUint(b.TradeCount, 10) },
+	"vwap":        func(b Bar, _ string) string { return formatFloat(b.VWAP) },
+}
+
+// defaultBarColumns is the column order used when none is configured
+var defaultBarColumns = []string{"timestamp", "open", "high", "low", "close", "volume", "trade_count", "vwap"}
+
+// checkColumns reports columns missing from the table
+func checkColumns[T any](table map[string]csvColumn[T], names []string) error {
+	for _, name := range names {
+		if _, ok := table[name]; !ok {
+			known := make([]string, 0, len(table))
+			for k := range table {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("unknown column %q (available: %s)", name, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+// csvWriter streams records into a CSV file with a header row
+type csvWriter[T any] struct {
+	file      *os.File
+	w         *csv.Writer
+	columns   []csvColumn[T]
+	timeStyle string
+	row       []string
+}
+
+// newCSVWriter creates the CSV file at location with the named columns of table
+func newCSVWriter[T any](location string, table map[string]csvColumn[T], names []string, timeStyle string) (*csvWriter[T], error) {
+	if err := checkColumns(table, names); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(location)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s: %w", location, err)
+	}
+
+	w := &csvWriter[T]{file: file, w: csv.NewWriter(file), timeStyle: timeStyle, row: make([]string, len(names))}
+	for _, name := range names {
+		w.columns = append(w.columns, table[name])
+	}
+	if err := w.w.Write(names); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error writing CSV header: %w", err)
+	}
+	return w, nil
+}
+
+// Write appends one record as a row
+func (w *csvWriter[T]) Write(v T) error {
+	for i, col := range w.columns {
+		w.row[i] = col(v, w.timeStyle)
+	}
+	return w.w.Write(w.row)
+}
+
+// Close flushes the rows and closes the file
+func (w *csvWriter[T]) Close() error {
+	w.w.Flush()
+	err := w.w.Error()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// latestQuote is a quote from a latest quotes endpoint. Sizes are fractional
+// for crypto, and crypto quotes carry no exchanges.
+type latestQuote struct {
+	Timestamp   time.Time `json:"t"`
+	BidExchange string    `json:"bx"`
+	BidPrice    float64   `json:"bp"`
+	BidSize     float64   `json:"bs"`
+	AskExchange string    `json:"ax"`
+	AskPrice    float64   `json:"ap"`
+	AskSize     float64   `json:"as"`
+}
+
+// latestQuoteRow is one symbol's latest quote as a table row
+type latestQuoteRow struct {
+	Symbol string `json:"symbol"`
+	// Venue is the crypto location the quote came from, empty for stocks
+	Venue       string    `json:"venue"`
+	Timestamp   time.Time `json:"timestamp"`
+	BidExchange string    `json:"bid_exchange"`
+	BidPrice    float64   `json:"bid_price"`
+	BidSize     float64   `json:"bid_size"`
+	AskExchange string    `json:"ask_exchange"`
+	AskPrice    float64   `json:"ask_price"`
+	AskSize     float64   `json:"ask_size"`
+}
+
+// newLatestQuoteRow returns the row of a symbol's latest quote
+func newLatestQuoteRow(symbol, venue string, q latestQuote) latestQuoteRow {
+	return latestQuoteRow{
+		Symbol:      symbol,
+		Venue:       venue,
+		Timestamp:   q.Timestamp,
+		BidExchange: q.BidExchange,
+		BidPrice:    q.BidPrice,
+		BidSize:     q.BidSize,
+		AskExchange: q.AskExchange,
+		AskPrice:    q.AskPrice,
+		AskSize:     q.AskSize,
+	}
+}
+
+// latestQuoteKind writes latest quote rows. It has no endpoint since latest quotes are not paged.
+var latestQuoteKind = dataKind[latestQuoteRow]{
+	columns:        latestQuoteColumns,
+	defaultColumns: defaultLatestQuoteColumns,
+	schema:         latestQuoteSchema,
+	appendRow:      appendLatestQuoteRow,
+}
+
+// latestQuoteColumns are the CSV columns available for latest quotes
+var latestQuoteColumns = map[string]csvColumn[latestQuoteRow]{
+	"symbol":       func(r latestQuoteRow, _ string) string { return r.Symbol },
+	"venue":        func(r latestQuoteRow, _ string) string { return r.Venue },
+	"timestamp":    func(r latestQuoteRow, style string) string { return formatTimestamp(r.Timestamp, style) },
+	"bid_exchange": func(r latestQuoteRow, _ string) string { return r.BidExchange },
+	"bid_price":    func(r latestQuoteRow, _ string) string { return formatFloat(r.BidPrice) },
+	"bid_size":     func(r latestQuoteRow, _ string) string { return formatFloat(r.BidSize) },
+	"ask_exchange": func(r latestQuoteRow, _ string) string { return r.AskExchange },
+	"ask_price":    func(r latestQuoteRow, _ string) string { return formatFloat(r.AskPrice) },
+	"ask_size":     func(r latestQuoteRow, _ string) string { return formatFloat(r.AskSize) },
+}
+
+// defaultLatestQuoteColumns is the column order used when none is configured
+var defaultLatestQuoteColumns = []string{"symbol", "venue", "timestamp", "bid_exchange", "bid_price", "bid_size", "ask_exchange", "ask_price", "ask_size"}
+
+// latestQuoteSchema is the Arrow schema latest quotes are written with
+var latestQuoteSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "symbol", Type: arrow.BinaryTypes.String},
+	{Name: "venue", Type: arrow.BinaryTypes.String},
+	{Name: "timestamp", Type: &arrow.TimestampType{Unit: arrow.Nanosecond, TimeZone: "UTC"}},
+	{Name: "bid_exchange", Type: arrow.BinaryTypes.String},
+	{Name: "bid_price", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "bid_size", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "ask_exchange", Type: arrow.BinaryTypes.String},
+	{Name: "ask_price", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "ask_size", Type: arrow.PrimitiveTypes.Float64},
+}, nil)
+
+// appendLatestQuoteRow adds one row of latestQuoteSchema
+func appendLatestQuoteRow(b *array.RecordBuilder, r latestQuoteRow) {
+	b.Field(0).(*array.StringBuilder).Append(r.Symbol)
+	b.Field(1).(*array.StringBuilder).Append(r.Venue)
+	b.Field(2).(*array.TimestampBuilder).Append(arrow.Timestamp(r.Timestamp.UnixNano()))
+	b.Field(3).(*array.StringBuilder).Append(r.BidExchange)
+	b.Field(4).(*array.Float64Builder).Append(r.BidPrice)
+	b.Field(5).(*array.Float64Builder).Append(r.BidSize)
+	b.Field(6).(*array.StringBuilder).Append(r.AskExchange)
+	b.Field(7).(*array.Float64Builder).Append(r.AskPrice)
+	b.Field(8).(*array.Float64Builder).Append(r.AskSize)
+}
+
+// getLatestQuotes fetches the latest quote of several symbols in one request.
+// The request decides between the stock and crypto endpoints; only its class,
+// feed and location are used.
+func (c *Client) getLatestQuotes(ctx context.Context, symbols []string, r FetchRequest) (map[string]latestQuote, error) {
+	q := url.Values{}
+	q.Set("symbols", strings.Join(symbols, ","))
+	endpoint := "quotes/latest"
+	if r.class() == classCrypto {
+		endpoint = "latest/quotes"
+	} else if r.Feed != "" {
+		q.Set("feed", r.Feed)
+	}
+	body, err := c.makeRequestURL(ctx, "GET", r.url(c, endpoint)+"?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Quotes map[string]latestQuote `json:"quotes"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		return nil, fmt.Errorf("error decoding latest quotes: %w", err)
+	}
+	return resp.Quotes, nil
+}
+
+// runLatest writes the latest quote of a list of stock or crypto symbols as one table
+func runLatest(args []string) error {
+	flags := flag.NewFlagSet("latest", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath, "fetch config file, for the watchlist cache and rate limit")
+	symbols := flags.String("symbols", "", "comma separated symbols, e.g. AAPL or BTC/USD")
+	symbolsFile := flags.String("symbols-file", "", "file listing symbols")
+	watchlist := flags.String("watchlist", "", "Alpaca watchlist to take symbols from")
+	class := flags.String("class", classStocks, "asset class (stocks or crypto)")
+	location := flags.String("location", defaultCryptoLocation, "crypto venue")
+	feed := flags.String("feed", "", "stock data feed (sip or iex)")
+	out := flags.String("out", "", "output file (default ./data/latest/quotes_<time> with the format's extension)")
+	format := flags.String("format", formatCSV, "output format (json, arrow or csv)")
+	columns := flags.String("columns", "", "comma separated CSV columns")
+	timeFormat := flags.String("time-format", "", "CSV timestamp style (rfc3339, epoch, epoch_ms or epoch_ns)")
+	flags.Parse(args)
+
+	req := FetchRequest{Class: *class, Location: *location, Feed: *feed}
+	if c := req.class(); c != classStocks && c != classCrypto {
+		return fmt.Errorf("unknown class %q (expected stocks or crypto)", *class)
+	}
+	var venue string
+	if req.class() == classCrypto {
+		venue = req.location()
+	}
+	spec := outputSpec{Format: *format, Columns: splitList(*columns), TimeStyle: *timeFormat}
+	if err := spec.check(latestQuoteKind); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfigOrDefault(*configPath)
+	if err != nil {
+		return err
+	}
+	client := NewClient()
+	if cfg.RateLimit > 0 {
+		client.RateLimiter = NewRateLimiter(cfg.RateLimit)
+	}
+	list, err := resolveSymbols(context.Background(), client, cfg, Profile{Symbols: splitSymbols(*symbols), SymbolsFile: *symbolsFile, Watchlist: *watchlist})
+	if err != nil {
+		return err
+	}
+
+	w, path, err := openTable(latestQuoteKind, spec, *out, "./data/latest/quotes")
+	if err != nil {
+		return err
+	}
+
+	written := 0
+	for i := 0; i < len(list) && err == nil; i += defaultBatchSize {
+		batch := list[i:min(i+defaultBatchSize, len(list))]
+		var quotes map[string]latestQuote
+		if quotes, err = client.getLatestQuotes(context.Background(), batch, req); err != nil {
+			break
+		}
+		for _, symbol := range batch {
+			q, ok := quotes[symbol]
+			if !ok {
+				fmt.Printf("  %-8s MISSING no quote\n", symbol)
+				continue
+			}
+			if err = w.Write(newLatestQuoteRow(symbol, venue, q)); err != nil {
+				break
+			}
+			written++
+		}
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	fmt.Printf("Wrote latest quotes of %d of %d symbols to %s\n", written, len(list), path)
+	return nil
+}
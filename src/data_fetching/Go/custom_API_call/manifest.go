@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultManifestPath is where downloaded coverage is tracked when the config does not say
+const defaultManifestPath = "./data/manifest.db"
+
+// coverageBucket holds the downloaded windows per series
+var coverageBucket = []byte("coverage")
+
+// checkpointBucket holds the progress of unfinished jobs
+var checkpointBucket = []byte("checkpoints")
+
+// window is the half-open time range [Start, End), to the nanosecond
+type window struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+func (w window) String() string {
+	return fmt.Sprintf("%s..%s", w.Start.UTC().Format(time.RFC3339Nano), w.End.UTC().Format(time.RFC3339Nano))
+}
+
+// seriesKey identifies one downloadable series. Feed and adjustment are part
+// of it because they change the bars returned for the same symbol and range.
+type seriesKey struct {
+	Symbol     string
+	Timeframe  string
+	Feed       string
+	Adjustment string
+}
+
+func (k seriesKey) bytes() []byte {
+	return []byte(strings.Join([]string{k.Symbol, k.Timeframe, k.Feed, k.Adjustment}, "|"))
+}
+
+// parseSeriesKey is the inverse of seriesKey.bytes
+func parseSeriesKey(b []byte) seriesKey {
+	parts := strings.SplitN(string(b), "|", 4)
+	for len(parts) < 4 {
+		parts = append(parts, "")
+	}
+	return seriesKey{Symbol: parts[0], Timeframe: parts[1], Feed: parts[2], Adjustment: parts[3]}
+}
+
+// Manifest records which windows of each series have been downloaded, so
+// re-runs only request what is missing
+type Manifest struct {
+	db *bolt.DB
+}
+
+// OpenManifest opens (or creates) the manifest database at path
+func OpenManifest(path string) (*Manifest, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating manifest directory: %w", err)
+	}
+	// Another fetch holding the manifest makes Open block, so give up after a moment
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening manifest %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(coverageBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(checkpointBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing manifest: %w", err)
+	}
+	return &Manifest{db: db}, nil
+}
+
+// Close closes the database
+func (m *Manifest) Close() error {
+	return m.db.Close()
+}
+
+// Covered returns the merged, sorted windows downloaded for a series
+func (m *Manifest) Covered(key seriesKey) ([]window, error) {
+	var covered []window
+	err := m.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(coverageBucket).Get(key.bytes())
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &covered)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest: %w", err)
+	}
+	return covered, nil
+}
+
+// Record marks w as downloaded for a series, merging it with the existing coverage
+func (m *Manifest) Record(key seriesKey, w window) error {
+	err := m.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(coverageBucket)
+		var covered []window
+		if data := bucket.Get(key.bytes()); data != nil {
+			if err := json.Unmarshal(data, &covered); err != nil {
+				return err
+			}
+		}
+
+		data, err := json.Marshal(mergeWindows(append(covered, w)))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key.bytes(), data)
+	})
+	if err != nil {
+		return fmt.Errorf("error updating manifest: %w", err)
+	}
+	return nil
+}
+
+// Missing returns the parts of want that are not covered for a series
+func (m *Manifest) Missing(key seriesKey, want window) ([]window, error) {
+	covered, err := m.Covered(key)
+	if err != nil {
+		return nil, err
+	}
+	return subtractWindows(want, covered), nil
+}
+
+// checkpoint is the progress of a job that has written some of its pages
+type checkpoint struct {
+	// PageToken is the first page not yet written
+	PageToken string `json:"page_token"`
+	// Part is the number of the part file the next page goes to
+	Part int `json:"part"`
+	// Counts holds the bars written per symbol so far
+	Counts map[string]int `json:"counts"`
+	// End is the end of the range the job was started with. The page token
+	// only applies to that range, so a resumed job keeps it.
+	End time.Time `json:"end"`
+	// Files are the files of the parts already written, and Pending the ones
+	// the current part writes to. Both are removed when the job is dropped.
+	Files   []string `json:"files"`
+	Pending []string `json:"pending"`
+}
+
+// Checkpoint returns the saved progress of a job, or the zero checkpoint when it has none
+func (m *Manifest) Checkpoint(job string) (checkpoint, error) {
+	var cp checkpoint
+	err := m.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(checkpointBucket).Get([]byte(job))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &cp)
+	})
+	if err != nil {
+		return cp, fmt.Errorf("error reading checkpoint: %w", err)
+	}
+	return cp, nil
+}
+
+// Checkpoints returns the saved progress of every job whose id starts with prefix
+func (m *Manifest) Checkpoints(prefix string) (map[string]checkpoint, error) {
+	cps := make(map[string]checkpoint)
+	err := m.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(checkpointBucket).Cursor()
+		for k, v := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, v = c.Next() {
+			var cp checkpoint
+			if err := json.Unmarshal(v, &cp); err != nil {
+				return err
+			}
+			cps[string(k)] = cp
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoints: %w", err)
+	}
+	return cps, nil
+}
+
+// SaveCheckpoint stores the progress of a job
+func (m *Manifest) SaveCheckpoint(job string, cp checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	err = m.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(checkpointBucket).Put([]byte(job), data)
+	})
+	if err != nil {
+		return fmt.Errorf("error saving checkpoint: %w", err)
+	}
+	return nil
+}
+
+// ClearCheckpoint drops the progress of a finished job
+func (m *Manifest) ClearCheckpoint(job string) error {
+	err := m.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(checkpointBucket).Delete([]byte(job))
+	})
+	if err != nil {
+		return fmt.Errorf("error clearing checkpoint: %w", err)
+	}
+	return nil
+}
+
+// mergeWindows sorts windows and joins those that overlap or touch
+func mergeWindows(windows []window) []window {
+	if len(windows) == 0 {
+		return nil
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Start.Before(windows[j].Start) })
+
+	merged := []window{windows[0]}
+	for _, w := range windows[1:] {
+		last := &merged[len(merged)-1]
+		if w.Start.After(last.End) {
+			merged = append(merged, w)
+			continue
+		}
+		if w.End.After(last.End) {
+			last.End = w.End
+		}
+	}
+	return merged
+}
+
+// subtractWindows returns the parts of want not in covered, which must be merged and sorted
+func subtractWindows(want window, covered []window) []window {
+	var missing []window
+	cur := want.Start
+	for _, c := range covered {
+		if !c.End.After(cur) {
+			continue
+		}
+		if !c.Start.Before(want.End) {
+			break
+		}
+		if c.Start.After(cur) {
+			missing = append(missing, window{Start: cur, End: c.Start})
+		}
+		cur = c.End
+	}
+	if cur.Before(want.End) {
+		missing = append(missing, window{Start: cur, End: want.End})
+	}
+	return missing
+}
+
+// clipWindows cuts the windows off at end, dropping those that start at or after it
+func clipWindows(windows []window, end time.Time) []window {
+	var clipped []window
+	for _, w := range windows {
+		if w.End.After(end) {
+			w.End = end
+		}
+		if w.Start.Before(w.End) {
+			clipped = append(clipped, w)
+		}
+	}
+	return clipped
+}
+
+// runManifest handles the manifest subcommands
+func runManifest(args []string) error {
+	if len(args) == 0 || args[0] != "show" {
+		return fmt.Errorf("manifest requires a subcommand (show)")
+	}
+
+	fs := flag.NewFlagSet("manifest show", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "fetch config file")
+	symbol := fs.String("symbol", "", "only show this symbol")
+	fs.Parse(args[1:])
+
+	cfg, err := loadConfigOrDefault(*configPath)
+	if err != nil {
+		return err
+	}
+	manifest, err := OpenManifest(cfg.Manifest)
+	if err != nil {
+		return err
+	}
+	defer manifest.Close()
+
+	return manifest.db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(coverageBucket).ForEach(func(k, v []byte) error {
+			key := parseSeriesKey(k)
+			if *symbol != "" && !strings.EqualFold(key.Symbol, *symbol) {
+				return nil
+			}
+			var covered []window
+			if err := json.Unmarshal(v, &covered); err != nil {
+				return err
+			}
+			fmt.Printf("%s %s feed=%s adjustment=%s\n", key.Symbol, key.Timeframe, key.Feed, key.Adjustment)
+			for _, w := range covered {
+				fmt.Printf("  %s\n", w)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Unfinished jobs resume from these on the next run with the same parameters
+		return tx.Bucket(checkpointBucket).ForEach(func(k, v []byte) error {
+			var cp checkpoint
+			if err := json.Unmarshal(v, &cp); err != nil {
+				return err
+			}
+			fmt.Printf("Unfinished %s: %d parts written\n", k, cp.Part)
+			return nil
+		})
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// at returns a time on 2024-01-02 UTC at the given offset from midnight
+func at(offset time.Duration) time.Time {
+	return time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC).Add(offset)
+}
+
+// span returns the window between two offsets from midnight of 2024-01-02
+func span(start, end time.Duration) window {
+	return window{Start: at(start), End: at(end)}
+}
+
+func TestMergeWindows(t *testing.T) {
+	tests := []struct {
+		name    string
+		windows []window
+		want    []window
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:    "single",
+			windows: []window{span(0, time.Hour)},
+			want:    []window{span(0, time.Hour)},
+		},
+		{
+			name:    "overlapping",
+			windows: []window{span(0, 2*time.Hour), span(time.Hour, 3*time.Hour)},
+			want:    []window{span(0, 3*time.Hour)},
+		},
+		{
+			name:    "contained",
+			windows: []window{span(0, 3*time.Hour), span(time.Hour, 2*time.Hour)},
+			want:    []window{span(0, 3*time.Hour)},
+		},
+		{
+			name:    "touching",
+			windows: []window{span(0, time.Hour), span(time.Hour, 2*time.Hour)},
+			want:    []window{span(0, 2*time.Hour)},
+		},
+		{
+			name:    "a nanosecond apart",
+			windows: []window{span(0, time.Hour), span(time.Hour+time.Nanosecond, 2*time.Hour)},
+			want:    []window{span(0, time.Hour), span(time.Hour+time.Nanosecond, 2*time.Hour)},
+		},
+		{
+			name:    "sub-second ends",
+			windows: []window{span(0, time.Hour+300*time.Millisecond), span(time.Hour+300*time.Millisecond, time.Hour+700*time.Millisecond)},
+			want:    []window{span(0, time.Hour+700*time.Millisecond)},
+		},
+		{
+			name:    "unsorted",
+			windows: []window{span(4*time.Hour, 5*time.Hour), span(0, time.Hour), span(30*time.Minute, 2*time.Hour)},
+			want:    []window{span(0, 2*time.Hour), span(4*time.Hour, 5*time.Hour)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeWindows(tt.windows); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeWindows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubtractWindows(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    window
+		covered []window
+		missing []window
+	}{
+		{
+			name:    "nothing covered",
+			want:    span(0, 4*time.Hour),
+			missing: []window{span(0, 4*time.Hour)},
+		},
+		{
+			name:    "all covered",
+			want:    span(time.Hour, 2*time.Hour),
+			covered: []window{span(0, 4*time.Hour)},
+		},
+		{
+			name:    "covered exactly",
+			want:    span(0, 4*time.Hour),
+			covered: []window{span(0, 4*time.Hour)},
+		},
+		{
+			name:    "hole in the middle",
+			want:    span(0, 4*time.Hour),
+			covered: []window{span(0, time.Hour), span(3*time.Hour, 4*time.Hour)},
+			missing: []window{span(time.Hour, 3*time.Hour)},
+		},
+		{
+			name:    "head and tail",
+			want:    span(0, 4*time.Hour),
+			covered: []window{span(time.Hour, 3*time.Hour)},
+			missing: []window{span(0, time.Hour), span(3*time.Hour, 4*time.Hour)},
+		},
+		{
+			name:    "covered touches the ends",
+			want:    span(time.Hour, 3*time.Hour),
+			covered: []window{span(0, time.Hour), span(3*time.Hour, 4*time.Hour)},
+			missing: []window{span(time.Hour, 3*time.Hour)},
+		},
+		{
+			name:    "nanosecond hole",
+			want:    span(0, 2*time.Hour),
+			covered: []window{span(0, time.Hour), span(time.Hour+time.Nanosecond, 2*time.Hour)},
+			missing: []window{span(time.Hour, time.Hour+time.Nanosecond)},
+		},
+		{
+			name:    "sub-second end",
+			want:    span(0, time.Hour+700*time.Millisecond),
+			covered: []window{span(0, time.Hour+300*time.Millisecond)},
+			missing: []window{span(time.Hour+300*time.Millisecond, time.Hour+700*time.Millisecond)},
+		},
+		{
+			name:    "covered outside want",
+			want:    span(2*time.Hour, 3*time.Hour),
+			covered: []window{span(0, time.Hour), span(4*time.Hour, 5*time.Hour)},
+			missing: []window{span(2*time.Hour, 3*time.Hour)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := subtractWindows(tt.want, tt.covered); !reflect.DeepEqual(got, tt.missing) {
+				t.Errorf("subtractWindows() = %v, want %v", got, tt.missing)
+			}
+		})
+	}
+}
+
+func TestClipWindows(t *testing.T) {
+	tests := []struct {
+		name    string
+		windows []window
+		end     time.Time
+		want    []window
+	}{
+		{
+			name:    "before the end",
+			windows: []window{span(0, time.Hour)},
+			end:     at(2 * time.Hour),
+			want:    []window{span(0, time.Hour)},
+		},
+		{
+			name:    "ending at the end",
+			windows: []window{span(0, time.Hour)},
+			end:     at(time.Hour),
+			want:    []window{span(0, time.Hour)},
+		},
+		{
+			name:    "across the end",
+			windows: []window{span(0, time.Hour), span(2*time.Hour, 4*time.Hour)},
+			end:     at(3*time.Hour + 30*time.Second),
+			want:    []window{span(0, time.Hour), span(2*time.Hour, 3*time.Hour+30*time.Second)},
+		},
+		{
+			name:    "starting at the end",
+			windows: []window{span(0, time.Hour), span(2*time.Hour, 3*time.Hour)},
+			end:     at(2 * time.Hour),
+			want:    []window{span(0, time.Hour)},
+		},
+		{
+			name:    "all after the end",
+			windows: []window{span(2*time.Hour, 3*time.Hour)},
+			end:     at(time.Hour),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clipWindows(tt.windows, tt.end); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("clipWindows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxPageLimit is the largest page size the data API accepts
+const maxPageLimit = 10000
+
+// Kinds of market data the fetcher downloads
+const (
+	dataBars   = "bars"
+	dataQuotes = "quotes"
+	dataTrades = "trades"
+)
+
+// Asset classes the fetcher downloads
+const (
+	classStocks = "stocks"
+	classCrypto = "crypto"
+)
+
+// defaultCryptoLocation is Alpaca's own crypto venue
+const defaultCryptoLocation = "us"
+
+// FetchRequest holds the parameters of a historical data request
+type FetchRequest struct {
+	// Class is the asset class, stocks when empty
+	Class string
+	// Data is the kind of data requested, bars when empty
+	Data string
+	// Timeframe only applies to bars
+	Timeframe string
+	// Feed and Adjustment only apply to stocks, Adjustment only to their bars
+	Feed       string
+	Adjustment string
+	// Location is the crypto venue, us when empty
+	Location string
+	// Start and End bound the half-open range [Start, End)
+	Start time.Time
+	End   time.Time
+}
+
+// class returns the asset class requested
+func (r FetchRequest) class() string {
+	if r.Class == "" {
+		return classStocks
+	}
+	return r.Class
+}
+
+// location returns the crypto venue requested
+func (r FetchRequest) location() string {
+	if r.Location == "" {
+		return defaultCryptoLocation
+	}
+	return r.Location
+}
+
+// kindName is the name of the request's data kind in kinds
+func (r FetchRequest) kindName() string {
+	if r.class() == classCrypto {
+		return classCrypto + "/" + r.data()
+	}
+	return r.data()
+}
+
+// url returns the address of an endpoint for the request's asset class
+func (r FetchRequest) url(c *Client, endpoint string) string {
+	if r.class() == classCrypto {
+		return c.CryptoURL + "/" + url.PathEscape(r.location()) + "/" + endpoint
+	}
+	return c.BaseURL + "/" + endpoint
+}
+
+// data returns the kind of data requested
+func (r FetchRequest) data() string {
+	if r.Data == "" {
+		return dataBars
+	}
+	return r.Data
+}
+
+// series names what is fetched per symbol: the timeframe for bars and the
+// kind of data otherwise. It is used in file names and manifest keys.
+func (r FetchRequest) series() string {
+	if r.data() == dataBars {
+		return r.Timeframe
+	}
+	return r.data()
+}
+
+// seriesKey returns the manifest key of a symbol's series. Crypto has no
+// feeds, so its venue takes the place of the feed.
+func (r FetchRequest) seriesKey(symbol string) seriesKey {
+	if r.class() == classCrypto {
+		return seriesKey{Symbol: symbol, Timeframe: r.series(), Feed: r.location()}
+	}
+	return seriesKey{Symbol: symbol, Timeframe: r.series(), Feed: r.Feed, Adjustment: r.Adjustment}
+}
+
+// query encodes the request parameters for the given symbols
+func (r FetchRequest) query(symbols []string) url.Values {
+	q := url.Values{}
+	q.Set("symbols", strings.Join(symbols, ","))
+	// The API's end is inclusive and timestamps are in nanoseconds, so the
+	// last nanosecond before End is asked for to leave End to the next request
+	q.Set("start", r.Start.UTC().Format(time.RFC3339Nano))
+	q.Set("end", r.End.Add(-time.Nanosecond).UTC().Format(time.RFC3339Nano))
+	q.Set("limit", fmt.Sprint(maxPageLimit))
+	q.Set("sort", "asc")
+	if r.data() == dataBars {
+		q.Set("timeframe", r.Timeframe)
+	}
+	if r.class() == classCrypto {
+		return q
+	}
+	if r.Feed != "" {
+		q.Set("feed", r.Feed)
+	}
+	if r.data() == dataBars && r.Adjustment != "" {
+		q.Set("adjustment", r.Adjustment)
+	}
+	return q
+}
+
+// streamPages fetches the multi-symbol endpoint at u, following
+// next_page_token until the range is exhausted. The records sit under key in
+// each response. Each page is handed to fn as it arrives, with the token of
+// the page after it, so long ranges are never held in memory. A symbol's
+// records may be split across consecutive pages. A non-empty pageToken
+// resumes an earlier stream at that page.
+func streamPages[T any](ctx context.Context, c *Client, u, key string, q url.Values, pageToken string, fn func(page map[string][]T, next string) error) error {
+	if pageToken != "" {
+		q.Set("page_token", pageToken)
+	}
+	for {
+		body, err := c.makeRequestURL(ctx, "GET", u+"?"+q.Encode())
+		if err != nil {
+			return err
+		}
+
+		var resp map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(body), &resp); err != nil {
+			return fmt.Errorf("error decoding %s: %w", key, err)
+		}
+		var page map[string][]T
+		if raw, ok := resp[key]; ok {
+			if err := json.Unmarshal(raw, &page); err != nil {
+				return fmt.Errorf("error decoding %s: %w", key, err)
+			}
+		}
+		var next string
+		if raw, ok := resp["next_page_token"]; ok {
+			var token *string
+			if err := json.Unmarshal(raw, &token); err != nil {
+				return fmt.Errorf("error decoding next_page_token: %w", err)
+			}
+			if token != nil {
+				next = *token
+			}
+		}
+
+		if err := fn(page, next); err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		q.Set("page_token", next)
+	}
+}
+
+// dataKind describes one kind of market data: where it is fetched from and
+// how its records are written
+type dataKind[T any] struct {
+	// endpoint is the path under the asset class's data API, and the key of the records in its responses
+	endpoint       string
+	columns        map[string]csvColumn[T]
+	defaultColumns []string
+	schema         *arrow.Schema
+	appendRow      func(b *array.RecordBuilder, v T)
+	// setVenue, when set, stamps each record with the venue it was fetched from
+	setVenue func(v *T, venue string)
+}
+
+// kind is the part of a dataKind that does not depend on its record type
+type kind interface {
+	checkColumns(names []string) error
+	run(j *fetchJob) error
+}
+
+// kinds maps the data names accepted in profiles to their kinds
+var kinds = map[string]kind{
+	dataBars:                       barKind,
+	dataQuotes:                     quoteKind,
+	dataTrades:                     tradeKind,
+	classCrypto + "/" + dataBars:   cryptoBarKind,
+	classCrypto + "/" + dataTrades: cryptoTradeKind,
+}
+
+// lookupKind returns the kind of data with the given name, bars when empty
+func lookupKind(name string) (kind, error) {
+	if name == "" {
+		name = dataBars
+	}
+	k, ok := kinds[name]
+	if !ok {
+		names := make([]string, 0, len(kinds))
+		for n := range kinds {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown data %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return k, nil
+}
+
+func (k dataKind[T]) checkColumns(names []string) error {
+	return checkColumns(k.columns, names)
+}
+
+// newWriter creates a writer for the spec at location
+func (k dataKind[T]) newWriter(spec outputSpec, location string) (recordWriter[T], error) {
+	switch spec.Format {
+	case formatArrow:
+		return newArrowWriter(location, k.schema, k.appendRow)
+	case formatCSV:
+		columns := spec.Columns
+		if len(columns) == 0 {
+			columns = k.defaultColumns
+		}
+		return newCSVWriter(location, k.columns, columns, spec.TimeStyle)
+	}
+	w, err := newJSONArrayWriter(location)
+	if err != nil {
+		return nil, err
+	}
+	return jsonWriter[T]{w}, nil
+}
+
+// run streams every page of the job into files per symbol. Every
+// checkpointPages pages the files are closed and the next page token saved, so
+// an interrupted job resumes there with a new part instead of starting over.
+// Files are only created for symbols that have records, and the files of the
+// part being written are removed again if the job fails.
+func (k dataKind[T]) run(j *fetchJob) error {
+	id := j.id()
+	cp, err := j.manifest.Checkpoint(id)
+	if err != nil {
+		return err
+	}
+	if !cp.End.IsZero() && !cp.End.Equal(j.req.End) {
+		// The checkpoint's pages belong to another range, so the job starts over
+		if err := dropCheckpoint(j.manifest, id, cp); err != nil {
+			return err
+		}
+		cp = checkpoint{}
+	}
+	if cp.End.IsZero() && j.checkpointPages > 0 {
+		// Saved up front so the files of an interrupted first part can be found
+		cp = checkpoint{End: j.req.End, Pending: j.partFiles(0)}
+		if err := j.manifest.SaveCheckpoint(id, cp); err != nil {
+			return err
+		}
+	}
+	files := cp.Files
+	counts := cp.Counts
+	if counts == nil {
+		counts = make(map[string]int)
+	}
+	part := cp.Part
+	writers := make(map[string]recordWriter[T])
+	pages := 0
+
+	venue := j.req.location()
+	err = streamPages(j.ctx, j.client, j.req.url(j.client, k.endpoint), k.endpoint, j.req.query(j.symbols), cp.PageToken, func(page map[string][]T, next string) error {
+		for symbol, records := range page {
+			w, ok := writers[symbol]
+			if !ok {
+				var err error
+				if w, err = k.newWriter(j.spec, j.location(symbol, part)); err != nil {
+					return err
+				}
+				writers[symbol] = w
+			}
+			for _, record := range records {
+				if k.setVenue != nil {
+					k.setVenue(&record, venue)
+				}
+				if err := w.Write(record); err != nil {
+					return fmt.Errorf("error writing %s: %w", symbol, err)
+				}
+			}
+			counts[symbol] += len(records)
+		}
+
+		pages++
+		if next == "" {
+			return nil
+		}
+		if j.checkpointPages > 0 && pages%j.checkpointPages == 0 {
+			if err := closeWriters(writers, nil); err != nil {
+				return err
+			}
+			for symbol := range writers {
+				files = append(files, j.location(symbol, part))
+			}
+			writers = make(map[string]recordWriter[T])
+			part++
+			cp := checkpoint{PageToken: next, Part: part, Counts: counts, End: j.req.End, Files: files, Pending: j.partFiles(part)}
+			if err := j.manifest.SaveCheckpoint(id, cp); err != nil {
+				return err
+			}
+		}
+		return j.ctx.Err()
+	})
+
+	err = closeWriters(writers, err)
+	if err != nil {
+		for symbol := range writers {
+			os.Remove(j.location(symbol, part))
+		}
+		return err
+	}
+	if j.checkpointPages > 0 {
+		if err := j.manifest.ClearCheckpoint(id); err != nil {
+			return err
+		}
+	}
+	j.counts = counts
+	return nil
+}
+
+// closeWriters closes every writer and returns err, or the first close error when err is nil
+func closeWriters[T any](writers map[string]recordWriter[T], err error) error {
+	for _, w := range writers {
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Output formats understood by the fetch commands
+const (
+	formatJSON  = "json"
+	formatArrow = "arrow"
+	formatCSV   = "csv"
+)
+
+// outputSpec describes the files a fetch job writes
+type outputSpec struct {
+	// Data names the kind of data written, as in kinds, which decides the available columns
+	Data   string
+	Format string
+	// Columns and TimeStyle only apply to CSV output
+	Columns   []string
+	TimeStyle string
+}
+
+// validate checks the data kind, the format and, for CSV, the columns and timestamp style
+func (s outputSpec) validate() error {
+	k, err := lookupKind(s.Data)
+	if err != nil {
+		return err
+	}
+	return s.check(k)
+}
+
+// check validates the spec for data of the given kind
+func (s outputSpec) check(k kind) error {
+	if _, err := formatExtension(s.Format); err != nil {
+		return err
+	}
+	if err := checkTimeStyle(s.TimeStyle); err != nil {
+		return err
+	}
+	return k.checkColumns(s.Columns)
+}
+
+// recordWriter streams records into one output file
+type recordWriter[T any] interface {
+	Write(v T) error
+	Close() error
+}
+
+// formatExtension returns the file extension of a format, validating it
+func formatExtension(format string) (string, error) {
+	switch format {
+	case "", formatJSON:
+		return ".json", nil
+	case formatArrow:
+		return ".arrow", nil
+	case formatCSV:
+		return ".csv", nil
+	default:
+		return "", fmt.Errorf("unknown output format %q (expected json, arrow or csv)", format)
+	}
+}
+
+// jsonWriter writes records as one JSON array
+type jsonWriter[T any] struct {
+	*jsonArrayWriter
+}
+
+func (w jsonWriter[T]) Write(v T) error {
+	return w.jsonArrayWriter.Write(v)
+}
+
+// jsonArrayWriter streams values into a file as a single JSON array
+type jsonArrayWriter struct {
+	file  *os.File
+	buf   *bufio.Writer
+	count int
+}
+
+// newJSONArrayWriter creates the file at location and opens the array
+func newJSONArrayWriter(location string) (*jsonArrayWriter, error) {
+	file, err := os.Create(location)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s: %w", location, err)
+	}
+	w := &jsonArrayWriter{file: file, buf: bufio.NewWriter(file)}
+	w.buf.WriteByte('[')
+	return w, nil
+}
+
+// Write appends one element to the array
+func (w *jsonArrayWriter) Write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error marshaling JSON: %w", err)
+	}
+	if w.count > 0 {
+		w.buf.WriteByte(',')
+	}
+	w.count++
+	_, err = w.buf.Write(data)
+	return err
+}
+
+// Close terminates the array and closes the file
+func (w *jsonArrayWriter) Close() error {
+	w.buf.WriteByte(']')
+	err := w.buf.Flush()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// gap is a stretch of missing data that holds at least one trading session
+type gap struct {
+	window
+	Sessions int
+}
+
+// planGaps splits the missing windows of a series into gaps worth downloading,
+// trimmed to the first and last session they touch, and the closed windows
+// around them in which the market did not trade. sessions must be in order.
+func planGaps(missing, sessions []window) ([]gap, []window) {
+	var gaps []gap
+	var closed []window
+	for _, w := range missing {
+		var g gap
+		for _, s := range sessions {
+			if !s.End.After(w.Start) || !s.Start.Before(w.End) {
+				continue
+			}
+			if g.Sessions == 0 {
+				g.Start = s.Start
+				if w.Start.After(s.Start) {
+					g.Start = w.Start
+				}
+			}
+			g.End = s.End
+			if w.End.Before(s.End) {
+				g.End = w.End
+			}
+			g.Sessions++
+		}
+
+		if g.Sessions == 0 {
+			closed = append(closed, w)
+			continue
+		}
+		gaps = append(gaps, g)
+		closed = append(closed, subtractWindows(w, []window{g.window})...)
+	}
+	return gaps, closed
+}
+
+// runPlan compares the manifest of a profile with the market calendar and
+// lists the gaps still to download, fetching them when asked to
+func runPlan(args []string) error {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath, "fetch config file")
+	profileName := flags.String("profile", "", "name of the profile to plan")
+	replayPath := flags.String("replay-log", defaultReplayLogPath(), "file to record every outgoing request to (empty to disable)")
+	rateLimit := flags.Int("rate-limit", 0, "requests per minute, overriding the config (negative for unlimited)")
+	execute := flags.Bool("execute", false, "download the planned gaps")
+	pf := newProfileFlags(flags)
+	flags.Parse(args)
+
+	cfg, name, profile, err := pf.load(*configPath, *profileName)
+	if err != nil {
+		return err
+	}
+	if *rateLimit != 0 {
+		cfg.RateLimit = *rateLimit
+	}
+	r, err := newRunner(cfg, *replayPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	ctx, stop := interruptContext()
+	defer stop()
+
+	now := time.Now()
+	start, end, err := profile.window(now)
+	if err != nil {
+		return err
+	}
+	want := window{Start: start, End: end}
+	barLength, err := profile.barLength()
+	if err != nil {
+		return err
+	}
+	symbols, err := resolveSymbols(ctx, r.client, cfg, profile)
+	if err != nil {
+		return err
+	}
+	missing, err := r.missing(profile, symbols, want)
+	if err != nil {
+		return err
+	}
+	// Crypto trades around the clock, so the whole range is one session
+	sessions := []window{want}
+	if profile.request(want).class() != classCrypto {
+		if sessions, err = r.client.marketSessions(ctx, want, barLength); err != nil {
+			return err
+		}
+	}
+
+	planned := make(map[string][]window, len(symbols))
+	closed := make(map[string][]window, len(symbols))
+	total, incomplete := 0, 0
+	for _, symbol := range symbols {
+		gaps, idle := planGaps(missing[symbol], sessions)
+		closed[symbol] = idle
+		if len(gaps) == 0 {
+			fmt.Printf("  %-8s complete\n", symbol)
+			continue
+		}
+		incomplete++
+		for _, g := range gaps {
+			planned[symbol] = append(planned[symbol], g.window)
+			total += g.Sessions
+			fmt.Printf("  %-8s %s  %d sessions\n", symbol, g.window, g.Sessions)
+		}
+	}
+	fmt.Printf("Plan for %s: %d sessions missing across %d of %d symbols\n", name, total, incomplete, len(symbols))
+
+	if !*execute {
+		return nil
+	}
+	// Nothing trades while the market is closed, so those windows are complete as they are
+	settled := now.Add(-barLength)
+	for _, symbol := range symbols {
+		for _, w := range closed[symbol] {
+			r.record(profile.request(w), []string{symbol}, settled)
+		}
+	}
+	return r.fetch(ctx, name, profile, symbols, planned, now)
+}
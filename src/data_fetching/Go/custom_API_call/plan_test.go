@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlanGaps(t *testing.T) {
+	day := 24 * time.Hour
+	// Regular hours sessions of three consecutive days
+	sessions := []window{
+		span(9*time.Hour+30*time.Minute, 16*time.Hour),
+		span(day+9*time.Hour+30*time.Minute, day+16*time.Hour),
+		span(2*day+9*time.Hour+30*time.Minute, 2*day+16*time.Hour),
+	}
+	tests := []struct {
+		name     string
+		missing  []window
+		sessions []window
+		gaps     []gap
+		closed   []window
+	}{
+		{
+			name:     "nothing missing",
+			sessions: sessions,
+		},
+		{
+			name:     "gap over several sessions",
+			missing:  []window{span(0, 3*day)},
+			sessions: sessions,
+			gaps:     []gap{{window: span(9*time.Hour+30*time.Minute, 2*day+16*time.Hour), Sessions: 3}},
+			closed:   []window{span(0, 9*time.Hour+30*time.Minute), span(2*day+16*time.Hour, 3*day)},
+		},
+		{
+			name:     "no sessions at all",
+			missing:  []window{span(0, 2*day)},
+			sessions: nil,
+			closed:   []window{span(0, 2*day)},
+		},
+		{
+			name:     "overnight between sessions",
+			missing:  []window{span(16*time.Hour, day+9*time.Hour+30*time.Minute)},
+			sessions: sessions,
+			closed:   []window{span(16*time.Hour, day+9*time.Hour+30*time.Minute)},
+		},
+		{
+			name:     "starts mid-session",
+			missing:  []window{span(12*time.Hour, day)},
+			sessions: sessions,
+			gaps:     []gap{{window: span(12*time.Hour, 16*time.Hour), Sessions: 1}},
+			closed:   []window{span(16*time.Hour, day)},
+		},
+		{
+			name:     "ends mid-session",
+			missing:  []window{span(20*time.Hour, day+12*time.Hour)},
+			sessions: sessions,
+			gaps:     []gap{{window: span(day+9*time.Hour+30*time.Minute, day+12*time.Hour), Sessions: 1}},
+			closed:   []window{span(20*time.Hour, day+9*time.Hour+30*time.Minute)},
+		},
+		{
+			name:     "inside one session",
+			missing:  []window{span(10*time.Hour, 11*time.Hour)},
+			sessions: sessions,
+			gaps:     []gap{{window: span(10*time.Hour, 11*time.Hour), Sessions: 1}},
+		},
+		{
+			name:     "several missing windows",
+			missing:  []window{span(0, 12*time.Hour), span(day+18*time.Hour, 3*day)},
+			sessions: sessions,
+			gaps: []gap{
+				{window: span(9*time.Hour+30*time.Minute, 12*time.Hour), Sessions: 1},
+				{window: span(2*day+9*time.Hour+30*time.Minute, 2*day+16*time.Hour), Sessions: 1},
+			},
+			closed: []window{
+				span(0, 9*time.Hour+30*time.Minute),
+				span(day+18*time.Hour, 2*day+9*time.Hour+30*time.Minute),
+				span(2*day+16*time.Hour, 3*day),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gaps, closed := planGaps(tt.missing, tt.sessions)
+			if !reflect.DeepEqual(gaps, tt.gaps) {
+				t.Errorf("planGaps() gaps = %v, want %v", gaps, tt.gaps)
+			}
+			if !reflect.DeepEqual(closed, tt.closed) {
+				t.Errorf("planGaps() closed = %v, want %v", closed, tt.closed)
+			}
+		})
+	}
+}
+
+func TestCalendarDaySession(t *testing.T) {
+	loc, err := time.LoadLocation(marketTimezone)
+	if err != nil {
+		t.Skipf("no timezone data: %v", err)
+	}
+	clock := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 2, hour, minute, 0, 0, loc)
+	}
+	tests := []struct {
+		name     string
+		day      calendarDay
+		wholeDay bool
+		want     window
+	}{
+		{
+			name:     "daily bars take the whole day",
+			day:      calendarDay{Date: "2024-01-02", Open: "09:30", Close: "16:00", SessionOpen: "0400", SessionClose: "2000"},
+			wholeDay: true,
+			want:     window{Start: clock(0, 0), End: time.Date(2024, 1, 3, 0, 0, 0, 0, loc)},
+		},
+		{
+			name: "intraday bars take extended hours",
+			day:  calendarDay{Date: "2024-01-02", Open: "09:30", Close: "16:00", SessionOpen: "0400", SessionClose: "2000"},
+			want: window{Start: clock(4, 0), End: clock(20, 0)},
+		},
+		{
+			name: "regular hours without session times",
+			day:  calendarDay{Date: "2024-01-02", Open: "09:30", Close: "16:00"},
+			want: window{Start: clock(9, 30), End: clock(16, 0)},
+		},
+		{
+			name: "early close",
+			day:  calendarDay{Date: "2024-01-02", Open: "09:30", Close: "13:00", SessionOpen: "0400", SessionClose: "1700"},
+			want: window{Start: clock(4, 0), End: clock(17, 0)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.day.session(loc, tt.wholeDay)
+			if err != nil {
+				t.Fatalf("session() error = %v", err)
+			}
+			if !got.Start.Equal(tt.want.Start) || !got.End.Equal(tt.want.End) {
+				t.Errorf("session() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Job is a unit of work run by a Pool
+type Job interface {
+	Run() error
+	String() string
+}
+
+// Pool runs jobs from a queue on a fixed number of workers, retrying failed jobs
+type Pool struct {
+	Workers int
+	// Retries is how many times a job failing with a server or network error is
+	// run again before it is reported as failed
+	Retries int
+	// RetryWait is the delay before the first retry, doubling on every further one
+	RetryWait time.Duration
+}
+
+// Run executes jobs read from queue until it is closed and every job finished.
+// report is called once per job with its final error and the attempts it took.
+// Calls to report are serialized, so it may update shared state freely.
+// Once ctx is cancelled no further jobs are taken from the queue or retried;
+// the jobs left in it are not reported.
+func (p Pool) Run(ctx context.Context, queue <-chan Job, report func(job Job, err error, attempts int)) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < max(1, p.Workers); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				if ctx.Err() != nil {
+					return
+				}
+				err, attempts := p.run(ctx, job)
+				mu.Lock()
+				report(job, err, attempts)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// run executes one job with retries
+func (p Pool) run(ctx context.Context, job Job) (error, int) {
+	wait := p.RetryWait
+	for attempt := 1; ; attempt++ {
+		err := job.Run()
+		if err == nil || attempt > p.Retries || !retryable(err) {
+			return err, attempt
+		}
+		select {
+		case <-ctx.Done():
+			return err, attempt
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// retryable reports whether running a job again may succeed. Client errors
+// mean the request itself is wrong, and 429 is not retried here because the
+// client already retries it per request, following Retry-After.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	return true
+}
+
+// queueJobs returns a closed queue holding the given jobs
+func queueJobs(jobs []Job) <-chan Job {
+	queue := make(chan Job, len(jobs))
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	return queue
+}
+
+// progress tracks aggregate progress over a set of jobs
+type progress struct {
+	total   int
+	done    int
+	failed  int
+	started time.Time
+}
+
+// update records a finished job and prints the running totals
+func (p *progress) update(job Job, err error, attempts int) {
+	p.done++
+	status := "ok"
+	if err != nil {
+		p.failed++
+		status = fmt.Sprintf("failed after %d attempts: %v", attempts, err)
+	}
+
+	elapsed := time.Since(p.started)
+	eta := time.Duration(float64(elapsed) / float64(p.done) * float64(p.total-p.done))
+	fmt.Printf("[%d/%d %3.0f%%] %s %s (%d failed, eta %v)\n",
+		p.done, p.total, 100*float64(p.done)/float64(p.total), job, status, p.failed, eta.Round(time.Second))
+}
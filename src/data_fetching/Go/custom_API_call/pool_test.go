@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "network error", err: errors.New("connection reset"), want: true},
+		{name: "server error", err: &StatusError{StatusCode: http.StatusBadGateway}, want: true},
+		{name: "bad request", err: &StatusError{StatusCode: http.StatusUnprocessableEntity}},
+		{name: "throttled", err: &StatusError{StatusCode: http.StatusTooManyRequests}},
+		{name: "client gave up on 429", err: fmt.Errorf("retries exceeded: %w", &StatusError{StatusCode: http.StatusTooManyRequests})},
+		{name: "cancelled", err: fmt.Errorf("page: %w", context.Canceled)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
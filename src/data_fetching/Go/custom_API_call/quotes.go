@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"strconv"
+	"time"
+)
+
+// Quote is a single NBBO quote as returned by the quotes endpoint
+type Quote struct {
+	Timestamp   time.Time `json:"t"`
+	BidExchange string    `json:"bx"`
+	BidPrice    float64   `json:"bp"`
+	BidSize     uint32    `json:"bs"`
+	AskExchange string    `json:"ax"`
+	AskPrice    float64   `json:"ap"`
+	AskSize     uint32    `json:"as"`
+	Conditions  []string  `json:"c"`
+	Tape        string    `json:"z"`
+}
+
+// quoteKind fetches quotes from the multi-symbol quotes endpoint
+var quoteKind = dataKind[Quote]{
+	endpoint:       "quotes",
+	columns:        quoteColumns,
+	defaultColumns: defaultQuoteColumns,
+	schema:         quoteSchema,
+	appendRow:      appendQuote,
+}
+
+// quoteColumns are the CSV columns available for quotes
+var quoteColumns = map[string]csvColumn[Quote]{
+	"timestamp":    func(q Quote, style string) string { return formatTimestamp(q.Timestamp, style) },
+	"bid_exchange": func(q Quote, _ string) string { return q.BidExchange },
+	"bid_price":    func(q Quote, _ string) string { return formatFloat(q.BidPrice) },
+	"bid_size":     func(q Quote, _ string) string { return strconv.FormatUint(uint64(q.BidSize), 10) },
+	"ask_exchange": func(q Quote, _ string) string { return q.AskExchange },
+	"ask_price":    func(q Quote, _ string) string { return formatFloat(q.AskPrice) },
+	"ask_size":     func(q Quote, _ string) string { return strconv.FormatUint(uint64(q.AskSize), 10) },
+	"conditions":   func(q Quote, _ string) string { return formatConditions(q.Conditions) },
+	"tape":         func(q Quote, _ string) string { return q.Tape },
+}
+
+// defaultQuoteColumns is the column order used when none is configured
+var defaultQuoteColumns = []string{"timestamp", "bid_exchange", "bid_price", "bid_size", "ask_exchange", "ask_price", "ask_size", "conditions", "tape"}
+
+// quoteSchema is the Arrow schema quotes are written with
+var quoteSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "timestamp", Type: &arrow.TimestampType{Unit: arrow.Nanosecond, TimeZone: "UTC"}},
+	{Name: "bid_exchange", Type: arrow.BinaryTypes.String},
+	{Name: "bid_price", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "bid_size", Type: arrow.PrimitiveTypes.Uint32},
+	{Name: "ask_exchange", Type: arrow.BinaryTypes.String},
+	{Name: "ask_price", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "ask_size", Type: arrow.PrimitiveTypes.Uint32},
+	{Name: "conditions", Type: arrow.ListOf(arrow.BinaryTypes.String)},
+	{Name: "tape", Type: arrow.BinaryTypes.String},
+}, nil)
+
+// appendQuote adds one quote as a row of quoteSchema
+func appendQuote(b *array.RecordBuilder, q Quote) {
+	b.Field(0).(*array.TimestampBuilder).Append(arrow.Timestamp(q.Timestamp.UnixNano()))
+	b.Field(1).(*array.StringBuilder).Append(q.BidExchange)
+	b.Field(2).(*array.Float64Builder).Append(q.BidPrice)
+	b.Field(3).(*array.Uint32Builder).Append(q.BidSize)
+	b.Field(4).(*array.StringBuilder).Append(q.AskExchange)
+	b.Field(5).(*array.Float64Builder).Append(q.AskPrice)
+	b.Field(6).(*array.Uint32Builder).Append(q.AskSize)
+	appendStrings(b.Field(7).(*array.ListBuilder), q.Conditions)
+	b.Field(8).(*array.StringBuilder).Append(q.Tape)
+}
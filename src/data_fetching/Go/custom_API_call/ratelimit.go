@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultRateLimit is the request budget per minute of Alpaca's free data plan
+const defaultRateLimit = 200
+
+// RateLimiter is a token bucket shared by every request a Client makes.
+// It refills at the configured requests per minute and holds up to a tenth
+// of a minute's budget, so bursts stay small. Alpaca's quota headers are
+// used to pull the bucket down to what the server says is left.
+type RateLimiter struct {
+	mu          sync.Mutex
+	perMinute   int
+	capacity    float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+// NewRateLimiter creates a limiter allowing perMinute requests per minute
+func NewRateLimiter(perMinute int) *RateLimiter {
+	l := &RateLimiter{last: time.Now()}
+	l.setRate(perMinute)
+	l.tokens = l.capacity
+	return l
+}
+
+// setRate changes the budget, keeping the current tokens within the new capacity
+func (l *RateLimiter) setRate(perMinute int) {
+	l.perMinute = perMinute
+	l.capacity = max(1, float64(perMinute)/10)
+	l.tokens = min(l.tokens, l.capacity)
+}
+
+// refill adds the tokens earned since the last call
+func (l *RateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+	l.tokens = min(l.capacity, l.tokens+elapsed*float64(l.perMinute)/60)
+}
+
+// Wait blocks until a request may be sent, or until ctx is cancelled. The
+// lock is only held to take a token, so quota headers observed meanwhile
+// still lower the rate or pause the bucket. It is a no-op on a nil limiter.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	for {
+		l.mu.Lock()
+		wait := l.reserve(time.Now())
+		l.mu.Unlock()
+		if wait <= 0 {
+			return nil
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve takes a token and returns zero, or returns how long to wait before trying again
+func (l *RateLimiter) reserve(now time.Time) time.Duration {
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+	l.refill(now)
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	// Just long enough for the next token
+	return time.Duration((1 - l.tokens) * 60 / float64(l.perMinute) * float64(time.Second))
+}
+
+// sleepContext sleeps for d, returning early with the context's error once it is cancelled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Observe adapts the limiter to the X-RateLimit-* headers of a response.
+// A lower plan limit than configured lowers the rate, the remaining count
+// caps the tokens, and an exhausted quota pauses until the reset time.
+func (l *RateLimiter) Observe(header http.Header) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit, err := strconv.Atoi(header.Get("X-RateLimit-Limit")); err == nil && limit > 0 && limit < l.perMinute {
+		l.setRate(limit)
+	}
+
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	l.refill(time.Now())
+	l.tokens = min(l.tokens, float64(remaining))
+	if remaining == 0 {
+		if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			l.pausedUntil = time.Unix(reset, 0)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterWait(t *testing.T) {
+	tests := []struct {
+		name    string
+		paused  time.Duration
+		cancel  time.Duration
+		wantErr error
+	}{
+		{name: "tokens left"},
+		{name: "cancelled while paused", paused: time.Hour, cancel: 20 * time.Millisecond, wantErr: context.Canceled},
+		{name: "pause runs out", paused: 20 * time.Millisecond, cancel: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRateLimiter(600)
+			l.pausedUntil = time.Now().Add(tt.paused)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel > 0 {
+				time.AfterFunc(tt.cancel, cancel)
+			}
+
+			done := make(chan error)
+			go func() { done <- l.Wait(ctx) }()
+			select {
+			case err := <-done:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Wait() error = %v, want %v", err, tt.wantErr)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Wait() did not return")
+			}
+		})
+	}
+}
+
+func TestRateLimiterObserveWhileWaiting(t *testing.T) {
+	l := NewRateLimiter(600)
+	l.pausedUntil = time.Now().Add(time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go l.Wait(ctx)
+	time.Sleep(10 * time.Millisecond)
+
+	// A waiting request must not hold the lock Observe needs
+	observed := make(chan struct{})
+	go func() {
+		header := http.Header{}
+		header.Set("X-RateLimit-Limit", fmt.Sprint(100))
+		l.Observe(header)
+		close(observed)
+	}()
+	select {
+	case <-observed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Observe blocked behind Wait")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perMinute != 100 {
+		t.Errorf("perMinute = %d, want 100", l.perMinute)
+	}
+}
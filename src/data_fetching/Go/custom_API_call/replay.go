@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// replayEntry is one line of the replay log, describing a single request attempt
+type replayEntry struct {
+	// Run tells apart the processes appending to the same log, since IDs restart at 1 in each
+	Run        string     `json:"run"`
+	ID         uint64     `json:"id"`
+	Attempt    int        `json:"attempt"`
+	Time       time.Time  `json:"time"`
+	Method     string     `json:"method"`
+	URL        string     `json:"url"`
+	Params     url.Values `json:"params"`
+	DurationMS float64    `json:"duration_ms"`
+	Status     int        `json:"status"`
+	Bytes      int64      `json:"bytes"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// failed reports whether the attempt did not produce a usable response
+func (e replayEntry) failed() bool {
+	return e.Error != "" || e.Status != http.StatusOK
+}
+
+// requestURL rebuilds the full URL of the logged request
+func (e replayEntry) requestURL() string {
+	if len(e.Params) == 0 {
+		return e.URL
+	}
+	return e.URL + "?" + e.Params.Encode()
+}
+
+// ReplayLog appends request attempts to a JSON lines file so a fetch run can be inspected and re-run
+type ReplayLog struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+	// run is stamped on every entry this process writes
+	run string
+}
+
+// NewReplayLog opens (or creates) the replay log at path
+func NewReplayLog(path string) (*ReplayLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating replay log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening replay log: %w", err)
+	}
+	run := time.Now().UTC().Format(time.RFC3339Nano)
+	return &ReplayLog{file: file, enc: json.NewEncoder(file), run: run}, nil
+}
+
+// defaultReplayLogPath generates a timestamped replay log name under ./logs
+func defaultReplayLogPath() string {
+	timestamp := time.Now().Format("20060102_150405")
+	return fmt.Sprintf("./logs/go_alpaca_replay_%s.jsonl", timestamp)
+}
+
+// Record writes one request attempt to the log. It is a no-op on a nil log.
+func (l *ReplayLog) Record(req *http.Request, id uint64, attempt int, started time.Time, status int, bytes int64, err error) {
+	if l == nil {
+		return
+	}
+
+	u := *req.URL
+	params := u.Query()
+	u.RawQuery = ""
+	entry := replayEntry{
+		Run:        l.run,
+		ID:         id,
+		Attempt:    attempt,
+		Time:       started.UTC(),
+		Method:     req.Method,
+		URL:        u.String(),
+		Params:     params,
+		DurationMS: float64(time.Since(started).Microseconds()) / 1000,
+		Status:     status,
+		Bytes:      bytes,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if encErr := l.enc.Encode(entry); encErr != nil {
+		fmt.Println("Error writing replay log:", encErr)
+	}
+}
+
+// Close closes the underlying file
+func (l *ReplayLog) Close() error {
+	return l.file.Close()
+}
+
+// readFailedRequests loads a replay log and returns the last attempt of every
+// request that never succeeded, de-duplicated by method and URL. A URL that
+// succeeded at any point in the log is not considered failed. Attempts are
+// grouped per run and request ID, as several runs may share a log.
+func readFailedRequests(path string) ([]replayEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening replay log: %w", err)
+	}
+	defer file.Close()
+
+	type requestKey struct {
+		run string
+		id  uint64
+	}
+	var order []requestKey
+	last := make(map[requestKey]replayEntry)
+	succeeded := make(map[string]bool)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry replayEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("error parsing replay log line %d: %w", line, err)
+		}
+		key := requestKey{entry.Run, entry.ID}
+		if _, seen := last[key]; !seen {
+			order = append(order, key)
+		}
+		last[key] = entry
+		if !entry.failed() {
+			succeeded[entry.Method+" "+entry.requestURL()] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading replay log: %w", err)
+	}
+
+	var failed []replayEntry
+	queued := make(map[string]bool)
+	for _, k := range order {
+		entry := last[k]
+		key := entry.Method + " " + entry.requestURL()
+		if succeeded[key] || queued[key] {
+			continue
+		}
+		queued[key] = true
+		failed = append(failed, entry)
+	}
+	return failed, nil
+}
+
+// runReplay re-issues the failed requests of a previous run
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	logPath := fs.String("log", "", "replay log of the run to re-issue failed requests from")
+	outDir := fs.String("out", "", "directory to write successful response bodies to (empty to discard)")
+	replayPath := fs.String("replay-log", defaultReplayLogPath(), "file to record the re-issued requests to (empty to disable)")
+	dryRun := fs.Bool("dry-run", false, "only list the failed requests")
+	rateLimit := fs.Int("rate-limit", defaultRateLimit, "requests per minute (0 for unlimited)")
+	fs.Parse(args)
+
+	if *logPath == "" {
+		return fmt.Errorf("replay requires -log")
+	}
+
+	failed, err := readFailedRequests(*logPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Found %d failed requests in %s\n", len(failed), *logPath)
+
+	if *dryRun {
+		for _, entry := range failed {
+			fmt.Printf("%s %s (status %d, %s)\n", entry.Method, entry.requestURL(), entry.Status, entry.Error)
+		}
+		return nil
+	}
+
+	client := NewClient()
+	if *rateLimit > 0 {
+		client.RateLimiter = NewRateLimiter(*rateLimit)
+	}
+	if *replayPath != "" {
+		replayLog, err := NewReplayLog(*replayPath)
+		if err != nil {
+			return err
+		}
+		defer replayLog.Close()
+		client.ReplayLog = replayLog
+	}
+	if *outDir != "" {
+		if err := os.MkdirAll(*outDir, 0755); err != nil {
+			return fmt.Errorf("error creating output directory: %w", err)
+		}
+	}
+
+	recovered := 0
+	for i, entry := range failed {
+		body, err := client.makeRequestURL(context.Background(), entry.Method, entry.requestURL())
+		if err != nil {
+			fmt.Printf("Replay of %s still failing: %v\n", entry.requestURL(), err)
+			continue
+		}
+		recovered++
+
+		if *outDir != "" {
+			location := filepath.Join(*outDir, fmt.Sprintf("replay_%d.json", i))
+			if err := os.WriteFile(location, []byte(body), 0644); err != nil {
+				fmt.Println("Error writing response:", err)
+			}
+		}
+	}
+
+	fmt.Printf("Recovered %d of %d failed requests\n", recovered, len(failed))
+	return nil
+}
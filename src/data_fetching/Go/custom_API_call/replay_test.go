@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadFailedRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []replayEntry
+		want    []string
+	}{
+		{
+			name: "retried until it succeeded",
+			entries: []replayEntry{
+				{Run: "r1", ID: 1, Attempt: 0, Method: "GET", URL: "a", Status: 429},
+				{Run: "r1", ID: 1, Attempt: 1, Method: "GET", URL: "a", Status: 200},
+			},
+		},
+		{
+			name: "never succeeded",
+			entries: []replayEntry{
+				{Run: "r1", ID: 1, Attempt: 0, Method: "GET", URL: "a", Status: 429},
+				{Run: "r1", ID: 1, Attempt: 1, Method: "GET", URL: "a", Status: 500},
+				{Run: "r1", ID: 2, Attempt: 0, Method: "GET", URL: "b", Status: 200},
+			},
+			want: []string{"a"},
+		},
+		{
+			name: "same id in a later run",
+			entries: []replayEntry{
+				{Run: "r1", ID: 1, Method: "GET", URL: "a", Status: 500},
+				{Run: "r2", ID: 1, Method: "GET", URL: "b", Status: 200},
+			},
+			want: []string{"a"},
+		},
+		{
+			name: "succeeded in a later run",
+			entries: []replayEntry{
+				{Run: "r1", ID: 1, Method: "GET", URL: "a", Status: 500},
+				{Run: "r2", ID: 3, Method: "GET", URL: "a", Status: 200},
+			},
+		},
+		{
+			name: "failed in two runs",
+			entries: []replayEntry{
+				{Run: "r1", ID: 1, Method: "GET", URL: "a", Error: "timeout"},
+				{Run: "r2", ID: 1, Method: "GET", URL: "a", Status: 500},
+			},
+			want: []string{"a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "replay.jsonl")
+			file, err := os.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			enc := json.NewEncoder(file)
+			for _, e := range tt.entries {
+				if err := enc.Encode(e); err != nil {
+					t.Fatal(err)
+				}
+			}
+			file.Close()
+
+			failed, err := readFailedRequests(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range failed {
+				got = append(got, e.URL)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("readFailedRequests() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("readFailedRequests() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
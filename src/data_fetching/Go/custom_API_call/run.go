@@ -0,0 +1,593 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// symbolResult is the outcome of fetching one symbol during a run
+type symbolResult struct {
+	Symbol string
+	// Records is the number of bars, quotes or trades written
+	Records int
+	// Current is set when there was nothing left to download
+	Current bool
+	Err     error
+}
+
+// profileFlags are the command line flags that override the fields of a profile
+type profileFlags struct {
+	flags    *flag.FlagSet
+	override Profile
+	symbols  string
+	columns  string
+}
+
+// newProfileFlags registers the profile override flags on flags
+func newProfileFlags(flags *flag.FlagSet) *profileFlags {
+	pf := &profileFlags{flags: flags}
+	flags.StringVar(&pf.symbols, "symbols", "", "comma separated symbols to fetch")
+	flags.StringVar(&pf.override.SymbolsFile, "symbols-file", "", "file listing symbols to fetch")
+	flags.StringVar(&pf.override.Watchlist, "watchlist", "", "Alpaca watchlist to take symbols from")
+	flags.StringVar(&pf.override.Class, "class", "", "asset class (stocks or crypto)")
+	flags.StringVar(&pf.override.Location, "location", "", "crypto venue, e.g. us")
+	flags.StringVar(&pf.override.Data, "data", "", "data to fetch (bars, quotes or trades)")
+	flags.StringVar(&pf.override.Timeframe, "timeframe", "", "bar timeframe, e.g. 1Min or 1Day")
+	flags.StringVar(&pf.override.Feed, "feed", "", "data feed (sip or iex)")
+	flags.StringVar(&pf.override.Adjustment, "adjustment", "", "corporate action adjustment (raw, split, dividend or all)")
+	flags.StringVar(&pf.override.Start, "start", "", "start time (RFC-3339 or YYYY-MM-DD)")
+	flags.StringVar(&pf.override.End, "end", "", "end time (RFC-3339 or YYYY-MM-DD), defaults to now")
+	flags.StringVar(&pf.override.Lookback, "lookback", "", "duration before end to fetch when no start is given")
+	flags.StringVar(&pf.override.Output, "out", "", "output directory")
+	flags.StringVar(&pf.override.Format, "format", "", "output format (json, arrow or csv)")
+	flags.StringVar(&pf.columns, "columns", "", "comma separated CSV columns")
+	flags.StringVar(&pf.override.TimeFormat, "time-format", "", "CSV timestamp style (rfc3339, epoch, epoch_ms or epoch_ns)")
+	flags.StringVar(&pf.override.Schedule, "schedule", "", "interval between runs (empty runs once)")
+	flags.IntVar(&pf.override.BatchSize, "batch-size", 0, "symbols per multi-symbol request")
+	flags.StringVar(&pf.override.Chunk, "chunk", "", "split the range into jobs of this duration (quotes and trades default to 24h)")
+	flags.IntVar(&pf.override.Workers, "workers", 0, "number of concurrent workers")
+	flags.IntVar(&pf.override.Retries, "retries", 0, "retries per failed job (negative to disable)")
+	flags.IntVar(&pf.override.CheckpointPages, "checkpoint-pages", 0, "pages written between checkpoints (negative to disable)")
+	return pf
+}
+
+// load reads the config and the named profile, applies the flags that were
+// given explicitly and validates the result. Without a profile name the flags
+// describe an ad-hoc job, which does not need a config file.
+func (pf *profileFlags) load(configPath, profileName string) (*Config, string, Profile, error) {
+	load := loadConfig
+	if profileName == "" {
+		load = loadConfigOrDefault
+	}
+	cfg, err := load(configPath)
+	if err != nil {
+		return nil, "", Profile{}, err
+	}
+
+	name := adHocProfile
+	var profile Profile
+	if profileName != "" {
+		name = profileName
+		if profile, err = cfg.profile(name); err != nil {
+			return nil, "", Profile{}, fmt.Errorf("profile %s: %w", name, err)
+		}
+	}
+
+	pf.flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "symbols":
+			profile.Symbols = splitSymbols(pf.symbols)
+		case "symbols-file":
+			profile.SymbolsFile = pf.override.SymbolsFile
+		case "watchlist":
+			profile.Watchlist = pf.override.Watchlist
+		case "class":
+			profile.Class = pf.override.Class
+		case "location":
+			profile.Location = pf.override.Location
+		case "data":
+			profile.Data = pf.override.Data
+		case "timeframe":
+			profile.Timeframe = pf.override.Timeframe
+		case "feed":
+			profile.Feed = pf.override.Feed
+		case "adjustment":
+			profile.Adjustment = pf.override.Adjustment
+		case "start":
+			profile.Start = pf.override.Start
+		case "end":
+			profile.End = pf.override.End
+		case "lookback":
+			profile.Lookback = pf.override.Lookback
+		case "out":
+			profile.Output = pf.override.Output
+		case "format":
+			profile.Format = pf.override.Format
+		case "columns":
+			profile.Columns = splitList(pf.columns)
+		case "time-format":
+			profile.TimeFormat = pf.override.TimeFormat
+		case "schedule":
+			profile.Schedule = pf.override.Schedule
+		case "batch-size":
+			profile.BatchSize = pf.override.BatchSize
+		case "chunk":
+			profile.Chunk = pf.override.Chunk
+		case "workers":
+			profile.Workers = pf.override.Workers
+		case "retries":
+			profile.Retries = pf.override.Retries
+		case "checkpoint-pages":
+			profile.CheckpointPages = pf.override.CheckpointPages
+		}
+	})
+	if err := profile.validate(); err != nil {
+		return nil, "", Profile{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return cfg, name, profile, nil
+}
+
+// adHocProfile is the name runs get that are described by flags alone
+const adHocProfile = "ad-hoc"
+
+// runFetch executes a named profile from the config, repeating it on its schedule.
+// Flags given on the command line override the profile, and without a profile
+// they describe an ad-hoc job.
+func runFetch(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath, "fetch config file")
+	profileName := flags.String("profile", "", "name of the profile to run")
+	replayPath := flags.String("replay-log", defaultReplayLogPath(), "file to record every outgoing request to (empty to disable)")
+	rateLimit := flags.Int("rate-limit", 0, "requests per minute, overriding the config (negative for unlimited)")
+	full := flags.Bool("full", false, "fetch the whole range even where the manifest says it was downloaded")
+	pf := newProfileFlags(flags)
+	flags.Parse(args)
+
+	cfg, name, profile, err := pf.load(*configPath, *profileName)
+	if err != nil {
+		return err
+	}
+	interval, _ := profile.interval()
+
+	if *rateLimit != 0 {
+		cfg.RateLimit = *rateLimit
+	}
+	r, err := newRunner(cfg, *replayPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	r.full = *full
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	for {
+		if err := r.runProfile(ctx, name, profile, time.Now()); err != nil {
+			if interval == 0 || ctx.Err() != nil {
+				return err
+			}
+			// A scheduled profile keeps going and tries again on the next run
+			fmt.Printf("Error running profile %s: %v\n", name, err)
+		}
+		if interval == 0 {
+			return nil
+		}
+
+		fmt.Printf("Next run of %s in %v\n", name, interval)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// interruptContext returns a context cancelled by Ctrl-C, which stops a run
+// from taking new jobs while the running ones stop at their next page. The
+// handler is removed after the first Ctrl-C, so a second one exits right away.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// fetchJob fetches one batch of symbols over one chunk of the range
+type fetchJob struct {
+	// ctx stops the job between pages once it is cancelled
+	ctx      context.Context
+	client   *Client
+	manifest *Manifest
+	// owner is the profile the job belongs to, so checkpoints of other profiles are left alone
+	owner   string
+	symbols []string
+	req     FetchRequest
+	output  string
+	spec    outputSpec
+	// checkpointPages is how many pages are written between checkpoints, zero for none
+	checkpointPages int
+	// counts holds the records written per symbol once the job succeeded
+	counts map[string]int
+}
+
+func (j *fetchJob) Run() error {
+	k, err := lookupKind(j.req.kindName())
+	if err != nil {
+		return err
+	}
+	return k.run(j)
+}
+
+func (j *fetchJob) String() string {
+	names := strings.Join(j.symbols, ",")
+	if len(j.symbols) > 3 {
+		names = fmt.Sprintf("%s +%d", strings.Join(j.symbols[:3], ","), len(j.symbols)-3)
+	}
+	return fmt.Sprintf("%s %s..%s", names, fileStamp(j.req.Start), fileStamp(j.req.End))
+}
+
+// scope identifies the profile, series and output of the job. The ids of all
+// jobs of a profile start with it.
+func (j *fetchJob) scope() string {
+	return strings.Join([]string{
+		j.owner, j.req.kindName(), j.req.series(), j.req.Feed, j.req.Adjustment, j.req.Location, j.output, j.spec.Format, "",
+	}, "|")
+}
+
+// id identifies the job across runs, for its checkpoint. The end is left out
+// since it moves with every run of a profile that fetches up to now.
+func (j *fetchJob) id() string {
+	return j.scope() + j.req.Start.UTC().Format(time.RFC3339Nano) + "|" + strings.Join(j.symbols, ",")
+}
+
+// location is the output file of one symbol of the job. Parts after the
+// first, written after a checkpoint, get a _partN suffix.
+func (j *fetchJob) location(symbol string, part int) string {
+	ext, _ := formatExtension(j.spec.Format)
+	// Crypto pairs such as BTC/USD would otherwise become directories
+	name := strings.ReplaceAll(symbol, "/", "-")
+	file := fmt.Sprintf("%s_%s_%s_%s", name, j.req.series(), fileStamp(j.req.Start), fileStamp(j.req.End))
+	if part > 0 {
+		file += fmt.Sprintf("_part%d", part)
+	}
+	return filepath.Join(j.output, file+ext)
+}
+
+// partFiles lists the files one part of the job writes when every symbol has records
+func (j *fetchJob) partFiles(part int) []string {
+	files := make([]string, len(j.symbols))
+	for i, symbol := range j.symbols {
+		files[i] = j.location(symbol, part)
+	}
+	return files
+}
+
+// drop removes the checkpoint of the job and the files it wrote
+func (j *fetchJob) drop() error {
+	cp, err := j.manifest.Checkpoint(j.id())
+	if err != nil {
+		return err
+	}
+	return dropCheckpoint(j.manifest, j.id(), cp)
+}
+
+// dropCheckpoint removes the files an unfinished job wrote along with its
+// checkpoint, so the job starts over instead of duplicating their records
+func dropCheckpoint(m *Manifest, id string, cp checkpoint) error {
+	for _, file := range append(cp.Files, cp.Pending...) {
+		if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("error removing %s: %w", file, err)
+		}
+	}
+	return m.ClearCheckpoint(id)
+}
+
+// fileStamp formats a time for file names, dropping the clock at midnight
+func fileStamp(t time.Time) string {
+	t = t.UTC()
+	if t.Equal(t.Truncate(24 * time.Hour)) {
+		return t.Format("20060102")
+	}
+	return t.Format("20060102T150405")
+}
+
+// runner carries what the runs of a profile share
+type runner struct {
+	client   *Client
+	cfg      *Config
+	manifest *Manifest
+	// full fetches the whole range, ignoring what the manifest says was downloaded
+	full bool
+}
+
+// newRunner sets up the client and opens the manifest for cfg.
+// An empty replayPath disables the replay log.
+func newRunner(cfg *Config, replayPath string) (*runner, error) {
+	client := NewClient()
+	if cfg.RateLimit > 0 {
+		client.RateLimiter = NewRateLimiter(cfg.RateLimit)
+	}
+	manifest, err := OpenManifest(cfg.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	if replayPath != "" {
+		replayLog, err := NewReplayLog(replayPath)
+		if err != nil {
+			manifest.Close()
+			return nil, err
+		}
+		client.ReplayLog = replayLog
+	}
+	return &runner{client: client, cfg: cfg, manifest: manifest}, nil
+}
+
+// Close closes the manifest and the replay log
+func (r *runner) Close() error {
+	if r.client.ReplayLog != nil {
+		r.client.ReplayLog.Close()
+	}
+	return r.manifest.Close()
+}
+
+// runProfile fetches every symbol of the profile once and writes the results.
+// Only the parts of the range missing from the manifest are requested.
+func (r *runner) runProfile(ctx context.Context, name string, p Profile, now time.Time) error {
+	start, end, err := p.window(now)
+	if err != nil {
+		return err
+	}
+	// Symbols are resolved on every run so watchlist changes are picked up
+	symbols, err := resolveSymbols(ctx, r.client, r.cfg, p)
+	if err != nil {
+		return err
+	}
+	missing, err := r.missing(p, symbols, window{Start: start, End: end})
+	if err != nil {
+		return err
+	}
+	return r.fetch(ctx, name, p, symbols, missing, now)
+}
+
+// missing returns the windows of want that each symbol still needs
+func (r *runner) missing(p Profile, symbols []string, want window) (map[string][]window, error) {
+	missing := make(map[string][]window, len(symbols))
+	for _, symbol := range symbols {
+		if r.full {
+			missing[symbol] = []window{want}
+			continue
+		}
+		windows, err := r.manifest.Missing(p.request(want).seriesKey(symbol), want)
+		if err != nil {
+			return nil, err
+		}
+		missing[symbol] = windows
+	}
+	return missing, nil
+}
+
+// fetch downloads the given windows of each symbol. The windows are split into
+// symbol batch by time chunk jobs that run on a worker pool. Cancelling ctx
+// stops the run early; what was not downloaded is fetched by the next one.
+func (r *runner) fetch(ctx context.Context, name string, p Profile, symbols []string, missing map[string][]window, now time.Time) error {
+	chunk, err := p.chunk()
+	if err != nil {
+		return err
+	}
+	barLength, err := p.barLength()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.Output, 0755); err != nil {
+		return fmt.Errorf("error creating output directory: %w", err)
+	}
+	// Ad-hoc runs share a name, so their symbols tell them apart
+	owner := name
+	if name == adHocProfile {
+		owner += ":" + strings.Join(symbols, ",")
+	}
+
+	results := make(map[string]*symbolResult, len(symbols))
+	for _, symbol := range symbols {
+		results[symbol] = &symbolResult{Symbol: symbol}
+	}
+
+	// The bar still forming at the end of the range is left to the next run,
+	// so no file holds a partial copy of it
+	settled := now.Add(-barLength)
+
+	// Symbols missing the same windows share batches, which after the
+	// first download of a profile is usually all of them
+	var groups [][]window
+	members := make(map[string][]string)
+	for _, symbol := range symbols {
+		windows := clipWindows(missing[symbol], settled)
+		if len(windows) == 0 {
+			results[symbol].Current = true
+			continue
+		}
+		id := fmt.Sprint(windows)
+		if _, ok := members[id]; !ok {
+			groups = append(groups, windows)
+		}
+		members[id] = append(members[id], symbol)
+	}
+
+	var jobs []Job
+	for _, windows := range groups {
+		group := members[fmt.Sprint(windows)]
+		for _, w := range windows {
+			for _, c := range splitRange(w, chunk) {
+				req := p.request(c)
+				for i := 0; i < len(group); i += p.batchSize() {
+					batch := group[i:min(i+p.batchSize(), len(group))]
+					resumed, err := r.resume(&fetchJob{
+						ctx:             ctx,
+						client:          r.client,
+						manifest:        r.manifest,
+						owner:           owner,
+						symbols:         batch,
+						req:             req,
+						output:          p.Output,
+						spec:            p.outputSpec(),
+						checkpointPages: p.checkpointPages(),
+					})
+					if err != nil {
+						return err
+					}
+					jobs = append(jobs, resumed...)
+				}
+			}
+		}
+	}
+	if err := r.dropStale(p, owner, jobs); err != nil {
+		return err
+	}
+
+	pool := Pool{Workers: p.workers(), Retries: p.retries(), RetryWait: time.Second}
+
+	// Run the batches, then fan failed batches out per symbol: one bad
+	// symbol fails its whole batch, and this finds out which one it was
+	for pass := 0; len(jobs) > 0; pass++ {
+		var fanOut []Job
+		tracker := &progress{total: len(jobs), started: time.Now()}
+		pool.Run(ctx, queueJobs(jobs), func(job Job, err error, attempts int) {
+			tracker.update(job, err, attempts)
+			j := job.(*fetchJob)
+			if err == nil {
+				for symbol, count := range j.counts {
+					if res, ok := results[symbol]; ok {
+						res.Records += count
+					}
+				}
+				r.record(j.req, j.symbols, settled)
+				return
+			}
+			if pass == 0 && len(j.symbols) > 1 && ctx.Err() == nil {
+				// The single symbol jobs start over, so what the batch wrote
+				// has to go first or its records would be there twice
+				dropErr := j.drop()
+				if dropErr == nil {
+					for _, symbol := range j.symbols {
+						single := *j
+						single.symbols = []string{symbol}
+						fanOut = append(fanOut, &single)
+					}
+					return
+				}
+				fmt.Printf("Error dropping the checkpoint of %s: %v\n", j, dropErr)
+			}
+			for _, symbol := range j.symbols {
+				if results[symbol].Err == nil {
+					results[symbol].Err = err
+				}
+			}
+		})
+		jobs = fanOut
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("run of %s interrupted: %w", name, err)
+	}
+
+	ordered := make([]symbolResult, 0, len(symbols))
+	for _, symbol := range symbols {
+		ordered = append(ordered, *results[symbol])
+	}
+	failures := printResults(name, p.request(window{}).data(), ordered)
+	if failures > 0 {
+		return fmt.Errorf("%d of %d symbols failed", failures, len(symbols))
+	}
+	return nil
+}
+
+// resume splits off the part of a job past the end its checkpoint was saved
+// with. The checkpointed part keeps that end, since its page token belongs to
+// it, and the rest becomes a job of its own.
+func (r *runner) resume(j *fetchJob) ([]Job, error) {
+	cp, err := r.manifest.Checkpoint(j.id())
+	if err != nil {
+		return nil, err
+	}
+	if !cp.End.After(j.req.Start) || !cp.End.Before(j.req.End) {
+		return []Job{j}, nil
+	}
+	rest := *j
+	rest.req.Start = cp.End
+	j.req.End = cp.End
+	return []Job{j, &rest}, nil
+}
+
+// dropStale drops the checkpoints of the profile that none of jobs resumes.
+// Their ranges or batches changed since, so their files would only duplicate
+// the records fetched now. owner is the profile as named in the job ids.
+func (r *runner) dropStale(p Profile, owner string, jobs []Job) error {
+	live := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		live[job.(*fetchJob).id()] = true
+	}
+	scope := (&fetchJob{owner: owner, req: p.request(window{}), output: p.Output, spec: p.outputSpec()}).scope()
+	cps, err := r.manifest.Checkpoints(scope)
+	if err != nil {
+		return err
+	}
+	for id, cp := range cps {
+		if live[id] {
+			continue
+		}
+		fmt.Printf("Dropping stale checkpoint %s\n", id)
+		if err := dropCheckpoint(r.manifest, id, cp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record marks the range of req as downloaded for symbols, up to settled.
+// Symbols without bars are recorded too, so empty ranges such as weekends are
+// not asked for again.
+func (r *runner) record(req FetchRequest, symbols []string, settled time.Time) {
+	w := window{Start: req.Start, End: req.End}
+	if w.End.After(settled) {
+		w.End = settled
+	}
+	if !w.End.After(w.Start) {
+		return
+	}
+	for _, symbol := range symbols {
+		if err := r.manifest.Record(req.seriesKey(symbol), w); err != nil {
+			// The data is on disk either way, it is only fetched again next run
+			fmt.Printf("Error recording %s in the manifest: %v\n", symbol, err)
+		}
+	}
+}
+
+// printResults reports the outcome of every symbol and returns the number of failures
+func printResults(name, data string, results []symbolResult) int {
+	failures := 0
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			failures++
+			fmt.Printf("  %-8s FAILED  %v\n", r.Symbol, r.Err)
+		case r.Current:
+			fmt.Printf("  %-8s CURRENT already downloaded\n", r.Symbol)
+		case r.Records == 0:
+			fmt.Printf("  %-8s EMPTY   no %s in range\n", r.Symbol, data)
+		default:
+			fmt.Printf("  %-8s OK      %d %s\n", r.Symbol, r.Records, data)
+		}
+	}
+	fmt.Printf("Profile %s: fetched %d of %d symbols\n", name, len(results)-failures, len(results))
+	return failures
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProfileFlagsLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fetch.json")
+	config := `{"profiles": {"daily": {"symbols": ["AAPL"], "timeframe": "1Day", "lookback": "24h"}}}`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		output  string
+		wantErr bool
+	}{
+		{name: "flag fills in a missing field", args: []string{"-out", "/tmp/x"}, output: "/tmp/x"},
+		{name: "missing field without a flag", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("run", flag.ContinueOnError)
+			pf := newProfileFlags(flags)
+			if err := flags.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			_, _, p, err := pf.load(path, "daily")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if p.Output != tt.output {
+				t.Errorf("load() output = %q, want %q", p.Output, tt.output)
+			}
+		})
+	}
+}
+
+func TestDropStaleKeepsOtherProfiles(t *testing.T) {
+	dir := t.TempDir()
+	m, err := OpenManifest(filepath.Join(dir, "manifest.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	r := &runner{manifest: m}
+
+	// Two profiles writing the same series into the same directory
+	p := Profile{Timeframe: "1Min", Output: dir, Format: formatCSV}
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	jobs := make(map[string]*fetchJob)
+	for _, owner := range []string{"aapl", "msft", "ad-hoc:AAPL", "ad-hoc:MSFT"} {
+		j := &fetchJob{owner: owner, symbols: []string{owner}, req: p.request(window{Start: start, End: start.Add(time.Hour)}), output: dir, spec: p.outputSpec()}
+		file := j.location(owner, 0)
+		if err := os.WriteFile(file, []byte("part"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.SaveCheckpoint(j.id(), checkpoint{End: j.req.End, Files: []string{file}}); err != nil {
+			t.Fatal(err)
+		}
+		jobs[owner] = j
+	}
+
+	// A later run of aapl plans a job with another start, so its old checkpoint is stale
+	live := *jobs["aapl"]
+	live.req.Start = start.Add(time.Minute)
+	if err := r.dropStale(p, "aapl", []Job{&live}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.dropStale(p, "ad-hoc:AAPL", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for owner, j := range jobs {
+		cp, err := m.Checkpoint(j.id())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, statErr := os.Stat(j.location(owner, 0))
+		dropped := owner == "aapl" || owner == "ad-hoc:AAPL"
+		if kept := !cp.End.IsZero(); kept == dropped {
+			t.Errorf("%s: checkpoint kept = %v, want %v", owner, kept, !dropped)
+		}
+		if gone := errors.Is(statErr, fs.ErrNotExist); gone != dropped {
+			t.Errorf("%s: file removed = %v, want %v", owner, gone, dropped)
+		}
+	}
+}
+
+// pagedServer serves a fixed number of pages of one-minute bars per request,
+// one bar per symbol and page. Page i holds the bars stamped i minutes after
+// the request's start, so every record tells which range and page it came from.
+type pagedServer struct {
+	pages int
+	// failFrom fails requests for a symbol with 422 from that page on
+	failFrom map[string]int
+
+	mu sync.Mutex
+	// requests maps each page token handed out to the request it continues
+	requests map[string]string
+	served   int
+	// cancel, when set, is called once cancelAfter pages were served
+	cancel      func()
+	cancelAfter int
+}
+
+func (s *pagedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := r.URL.Query()
+	request := q.Get("symbols") + " " + q.Get("start") + " " + q.Get("end")
+	page := 0
+	if token := q.Get("page_token"); token != "" {
+		// A token only belongs to the request it came with
+		if s.requests[token] != request {
+			http.Error(w, "page token of another request", http.StatusBadRequest)
+			return
+		}
+		_, n, _ := strings.Cut(token, ":")
+		page, _ = strconv.Atoi(n)
+	}
+	symbols := strings.Split(q.Get("symbols"), ",")
+	for _, symbol := range symbols {
+		if from, ok := s.failFrom[symbol]; ok && page >= from {
+			http.Error(w, "invalid symbol", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	start, err := time.Parse(time.RFC3339Nano, q.Get("start"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stamp := start.Add(time.Duration(page) * time.Minute).Format(time.RFC3339)
+	bars := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		bars[i] = fmt.Sprintf(`%q:[{"t":%q,"o":1,"h":1,"l":1,"c":1,"v":1}]`, symbol, stamp)
+	}
+	next := "null"
+	if page+1 < s.pages {
+		token := fmt.Sprintf("t%d:%d", len(s.requests), page+1)
+		s.requests[token] = request
+		next = strconv.Quote(token)
+	}
+	fmt.Fprintf(w, `{"bars":{%s},"next_page_token":%s}`, strings.Join(bars, ","), next)
+
+	s.served++
+	if s.cancel != nil && s.served == s.cancelAfter {
+		s.cancel()
+	}
+}
+
+// readStamps returns the timestamps written per symbol across the CSV files in dir
+func readStamps(t *testing.T, dir string) map[string][]string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamps := make(map[string][]string)
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := csv.NewReader(f).ReadAll()
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		symbol, _, _ := strings.Cut(filepath.Base(file), "_")
+		for _, row := range rows[1:] {
+			stamps[symbol] = append(stamps[symbol], row[0])
+		}
+	}
+	return stamps
+}
+
+func TestFetchCheckpoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		symbols []string
+		// failFrom is passed on to the server
+		failFrom map[string]int
+		// interruptAfter cancels the first run after this many pages, zero runs it through
+		interruptAfter int
+		// endMoves is how much later the run resuming the interrupted one starts
+		endMoves time.Duration
+		// records is the number of distinct bars expected per symbol in the end
+		records map[string]int
+		// checkpoints is the number of checkpoints expected to be left
+		checkpoints int
+		wantErr     bool
+	}{
+		{
+			name:    "uninterrupted",
+			symbols: []string{"AAPL", "MSFT"},
+			records: map[string]int{"AAPL": 4, "MSFT": 4},
+		},
+		{
+			name:           "interrupted then resumed",
+			symbols:        []string{"AAPL", "MSFT"},
+			interruptAfter: 2,
+			records:        map[string]int{"AAPL": 4, "MSFT": 4},
+		},
+		{
+			name:           "resumed after the end moved",
+			symbols:        []string{"AAPL", "MSFT"},
+			interruptAfter: 2,
+			endMoves:       time.Hour,
+			// The resumed job keeps its end and the hour after it is a job of its own
+			records: map[string]int{"AAPL": 8, "MSFT": 8},
+		},
+		{
+			name:     "failed batch fanned out",
+			symbols:  []string{"AAPL", "BAD"},
+			failFrom: map[string]int{"BAD": 2},
+			// BAD keeps the pages before its failure for the next run to resume
+			records:     map[string]int{"AAPL": 4, "BAD": 2},
+			checkpoints: 1,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &pagedServer{pages: 4, failFrom: tt.failFrom, requests: make(map[string]string)}
+			srv := httptest.NewServer(server)
+			defer srv.Close()
+
+			dir := t.TempDir()
+			m, err := OpenManifest(filepath.Join(dir, "manifest.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Close()
+			client := NewClient()
+			client.BaseURL = srv.URL
+			r := &runner{client: client, cfg: &Config{}, manifest: m}
+			out := filepath.Join(dir, "out")
+			p := Profile{
+				Symbols:         tt.symbols,
+				Timeframe:       "1Min",
+				Start:           "2024-01-02",
+				Output:          out,
+				Format:          formatCSV,
+				Retries:         -1,
+				CheckpointPages: 1,
+			}
+			now := time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC)
+
+			if tt.interruptAfter > 0 {
+				ctx, cancel := context.WithCancel(context.Background())
+				server.cancel, server.cancelAfter = cancel, tt.interruptAfter
+				if err := r.runProfile(ctx, "test", p, now); !errors.Is(err, context.Canceled) {
+					t.Fatalf("interrupted run error = %v, want %v", err, context.Canceled)
+				}
+				server.cancel = nil
+				now = now.Add(tt.endMoves)
+			}
+			if err := r.runProfile(context.Background(), "test", p, now); (err != nil) != tt.wantErr {
+				t.Fatalf("runProfile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			stamps := readStamps(t, out)
+			for symbol, want := range tt.records {
+				seen := make(map[string]bool)
+				for _, stamp := range stamps[symbol] {
+					if seen[stamp] {
+						t.Errorf("%s: bar %s written twice", symbol, stamp)
+					}
+					seen[stamp] = true
+				}
+				if len(seen) != want {
+					t.Errorf("%s: %d bars written, want %d", symbol, len(seen), want)
+				}
+			}
+			cps, err := m.Checkpoints("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cps) != tt.checkpoints {
+				t.Errorf("%d checkpoints left, want %d", len(cps), tt.checkpoints)
+			}
+		})
+	}
+}
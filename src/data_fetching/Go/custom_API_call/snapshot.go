@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshot is the latest market state of a symbol as returned by the snapshots endpoint
+type snapshot struct {
+	LatestTrade  *Trade `json:"latestTrade"`
+	LatestQuote  *Quote `json:"latestQuote"`
+	MinuteBar    *Bar   `json:"minuteBar"`
+	DailyBar     *Bar   `json:"dailyBar"`
+	PrevDailyBar *Bar   `json:"prevDailyBar"`
+}
+
+// snapshotRow is one symbol's snapshot flattened into a table row.
+// Parts missing from the snapshot are left zero.
+type snapshotRow struct {
+	Symbol        string    `json:"symbol"`
+	TradeTime     time.Time `json:"trade_time"`
+	TradePrice    float64   `json:"trade_price"`
+	TradeSize     uint32    `json:"trade_size"`
+	TradeExchange string    `json:"trade_exchange"`
+	QuoteTime     time.Time `json:"quote_time"`
+	BidPrice      float64   `json:"bid_price"`
+	BidSize       uint32    `json:"bid_size"`
+	AskPrice      float64   `json:"ask_price"`
+	AskSize       uint32    `json:"ask_size"`
+	Open          float64   `json:"open"`
+	High          float64   `json:"high"`
+	Low           float64   `json:"low"`
+	Close         float64   `json:"close"`
+	Volume        uint64    `json:"volume"`
+	PrevClose     float64   `json:"prev_close"`
+	// ChangePercent is the latest trade against the previous close
+	ChangePercent float64 `json:"change_percent"`
+}
+
+// row flattens the snapshot of symbol
+func (s snapshot) row(symbol string) snapshotRow {
+	r := snapshotRow{Symbol: symbol}
+	if t := s.LatestTrade; t != nil {
+		r.TradeTime, r.TradePrice, r.TradeSize, r.TradeExchange = t.Timestamp, t.Price, t.Size, t.Exchange
+	}
+	if q := s.LatestQuote; q != nil {
+		r.QuoteTime, r.BidPrice, r.BidSize, r.AskPrice, r.AskSize = q.Timestamp, q.BidPrice, q.BidSize, q.AskPrice, q.AskSize
+	}
+	if b := s.DailyBar; b != nil {
+		r.Open, r.High, r.Low, r.Close, r.Volume = b.Open, b.High, b.Low, b.Close, b.Volume
+	}
+	if b := s.PrevDailyBar; b != nil {
+		r.PrevClose = b.Close
+		if r.PrevClose != 0 && r.TradePrice != 0 {
+			r.ChangePercent = (r.TradePrice/r.PrevClose - 1) * 100
+		}
+	}
+	return r
+}
+
+// snapshotKind writes snapshot rows. It has no endpoint since snapshots are not paged.
+var snapshotKind = dataKind[snapshotRow]{
+	columns:        snapshotColumns,
+	defaultColumns: defaultSnapshotColumns,
+	schema:         snapshotSchema,
+	appendRow:      appendSnapshotRow,
+}
+
+// snapshotTime renders a timestamp, leaving it empty when the snapshot had none
+func snapshotTime(t time.Time, style string) string {
+	if t.IsZero() {
+		return ""
+	}
+	return formatTimestamp(t, style)
+}
+
+// snapshotColumns are the CSV columns available for snapshots
+var snapshotColumns = map[string]csvColumn[snapshotRow]{
+	"symbol":         func(r snapshotRow, _ string) string { return r.Symbol },
+	"trade_time":     func(r snapshotRow, style string) string { return snapshotTime(r.TradeTime, style) },
+	"trade_price":    func(r snapshotRow, _ string) string { return formatFloat(r.TradePrice) },
+	"trade_size":     func(r snapshotRow, _ string) string { return strconv.FormatUint(uint64(r.TradeSize), 10) },
+	"trade_exchange": func(r snapshotRow, _ string) string { return r.TradeExchange },
+	"quote_time":     func(r snapshotRow, style string) string { return snapshotTime(r.QuoteTime, style) },
+	"bid_price":      func(r snapshotRow, _ string) string { return formatFloat(r.BidPrice) },
+	"bid_size":       func(r snapshotRow, _ string) string { return strconv.FormatUint(uint64(r.BidSize), 10) },
+	"ask_price":      func(r snapshotRow, _ string) string { return formatFloat(r.AskPrice) },
+	"ask_size":       func(r snapshotRow, _ string) string { return strconv.FormatUint(uint64(r.AskSize), 10) },
+	"open":           func(r snapshotRow, _ string) string { return formatFloat(r.Open) },
+	"high":           func(r snapshotRow, _ string) string { return formatFloat(r.High) },
+	"low":            func(r snapshotRow, _ string) string { return formatFloat(r.Low) },
+	"close":          func(r snapshotRow, _ string) string { return formatFloat(r.Close) },
+	"volume":         func(r snapshotRow, _ string) string { return strconv.FormatUint(r.Volume, 10) },
+	"prev_close":     func(r snapshotRow, _ string) string { return formatFloat(r.PrevClose) },
+	"change_percent": func(r snapshotRow, _ string) string { return strconv.FormatFloat(r.ChangePercent, 'f', 2, 64) },
+}
+
+// defaultSnapshotColumns is the column order used when none is configured
+var defaultSnapshotColumns = []string{
+	"symbol", "trade_time", "trade_price", "trade_size", "trade_exchange",
+	"quote_time", "bid_price", "bid_size", "ask_price", "ask_size",
+	"open", "high", "low", "close", "volume", "prev_close", "change_percent",
+}
+
+// snapshotSchema is the Arrow schema snapshots are written with
+var snapshotSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "symbol", Type: arrow.BinaryTypes.String},
+	{Name: "trade_time", Type: &arrow.TimestampType{Unit: arrow.Nanosecond, TimeZone: "UTC"}, Nullable: true},
+	{Name: "trade_price", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "trade_size", Type: arrow.PrimitiveTypes.Uint32},
+	{Name: "trade_exchange", Type: arrow.BinaryTypes.String},
+	{Name: "quote_time", Type: &arrow.TimestampType{Unit: arrow.Nanosecond, TimeZone: "UTC"}, Nullable: true},
+	{Name: "bid_price", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "bid_size", Type: arrow.PrimitiveTypes.Uint32},
+	{Name: "ask_price", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "ask_size", Type: arrow.PrimitiveTypes.Uint32},
+	{Name: "open", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "high", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "low", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "close", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "volume", Type: arrow.PrimitiveTypes.Uint64},
+	{Name: "prev_close", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "change_percent", Type: arrow.PrimitiveTypes.Float64},
+}, nil)
+
+// appendSnapshotRow adds one row of snapshotSchema
+func appendSnapshotRow(b *array.RecordBuilder, r snapshotRow) {
+	appendTime := func(tb *array.TimestampBuilder, t time.Time) {
+		if t.IsZero() {
+			tb.AppendNull()
+			return
+		}
+		tb.Append(arrow.Timestamp(t.UnixNano()))
+	}
+	b.Field(0).(*array.StringBuilder).Append(r.Symbol)
+	appendTime(b.Field(1).(*array.TimestampBuilder), r.TradeTime)
+	b.Field(2).(*array.Float64Builder).Append(r.TradePrice)
+	b.Field(3).(*array.Uint32Builder).Append(r.TradeSize)
+	b.Field(4).(*array.StringBuilder).Append(r.TradeExchange)
+	appendTime(b.Field(5).(*array.TimestampBuilder), r.QuoteTime)
+	b.Field(6).(*array.Float64Builder).Append(r.BidPrice)
+	b.Field(7).(*array.Uint32Builder).Append(r.BidSize)
+	b.Field(8).(*array.Float64Builder).Append(r.AskPrice)
+	b.Field(9).(*array.Uint32Builder).Append(r.AskSize)
+	b.Field(10).(*array.Float64Builder).Append(r.Open)
+	b.Field(11).(*array.Float64Builder).Append(r.High)
+	b.Field(12).(*array.Float64Builder).Append(r.Low)
+	b.Field(13).(*array.Float64Builder).Append(r.Close)
+	b.Field(14).(*array.Uint64Builder).Append(r.Volume)
+	b.Field(15).(*array.Float64Builder).Append(r.PrevClose)
+	b.Field(16).(*array.Float64Builder).Append(r.ChangePercent)
+}
+
+// getSnapshots fetches the snapshots of several symbols in one request.
+// Symbols the API knows nothing about are missing from the result.
+func (c *Client) getSnapshots(ctx context.Context, symbols []string, feed string) (map[string]snapshot, error) {
+	q := url.Values{}
+	q.Set("symbols", strings.Join(symbols, ","))
+	if feed != "" {
+		q.Set("feed", feed)
+	}
+	body, err := c.makeRequest(ctx, "GET", "snapshots?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots map[string]snapshot
+	if err := json.Unmarshal([]byte(body), &snapshots); err != nil {
+		return nil, fmt.Errorf("error decoding snapshots: %w", err)
+	}
+	return snapshots, nil
+}
+
+// runSnapshot writes the latest trade, quote and daily bar of a list of symbols as one table
+func runSnapshot(args []string) error {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath, "fetch config file, for the watchlist cache and rate limit")
+	symbols := flags.String("symbols", "", "comma separated symbols")
+	symbolsFile := flags.String("symbols-file", "", "file listing symbols")
+	watchlist := flags.String("watchlist", "", "Alpaca watchlist to take symbols from")
+	feed := flags.String("feed", "", "data feed (sip or iex)")
+	out := flags.String("out", "", "output file (default ./data/snapshots/snapshot_<time> with the format's extension)")
+	format := flags.String("format", formatCSV, "output format (json, arrow or csv)")
+	columns := flags.String("columns", "", "comma separated CSV columns")
+	timeFormat := flags.String("time-format", "", "CSV timestamp style (rfc3339, epoch, epoch_ms or epoch_ns)")
+	flags.Parse(args)
+
+	spec := outputSpec{Format: *format, Columns: splitList(*columns), TimeStyle: *timeFormat}
+	if err := spec.check(snapshotKind); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfigOrDefault(*configPath)
+	if err != nil {
+		return err
+	}
+	client := NewClient()
+	if cfg.RateLimit > 0 {
+		client.RateLimiter = NewRateLimiter(cfg.RateLimit)
+	}
+	list, err := resolveSymbols(context.Background(), client, cfg, Profile{Symbols: splitSymbols(*symbols), SymbolsFile: *symbolsFile, Watchlist: *watchlist})
+	if err != nil {
+		return err
+	}
+
+	w, location, err := openTable(snapshotKind, spec, *out, "./data/snapshots/snapshot")
+	if err != nil {
+		return err
+	}
+
+	written := 0
+	for i := 0; i < len(list) && err == nil; i += defaultBatchSize {
+		batch := list[i:min(i+defaultBatchSize, len(list))]
+		var snapshots map[string]snapshot
+		if snapshots, err = client.getSnapshots(context.Background(), batch, *feed); err != nil {
+			break
+		}
+		for _, symbol := range batch {
+			s, ok := snapshots[symbol]
+			if !ok {
+				fmt.Printf("  %-8s MISSING no snapshot\n", symbol)
+				continue
+			}
+			if err = w.Write(s.row(symbol)); err != nil {
+				break
+			}
+			written++
+		}
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(location)
+		return err
+	}
+
+	fmt.Printf("Wrote snapshots of %d of %d symbols to %s\n", written, len(list), location)
+	return nil
+}
+
+// openTable creates the writer of a single table command. An empty location
+// is generated from prefix, the current time and the format's extension.
+func openTable[T any](k dataKind[T], spec outputSpec, location, prefix string) (recordWriter[T], string, error) {
+	if location == "" {
+		ext, _ := formatExtension(spec.Format)
+		location = fmt.Sprintf("%s_%s%s", prefix, time.Now().Format("20060102_150405"), ext)
+	}
+	if err := os.MkdirAll(filepath.Dir(location), 0755); err != nil {
+		return nil, "", fmt.Errorf("error creating output directory: %w", err)
+	}
+	w, err := k.newWriter(spec, location)
+	return w, location, err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"strconv"
+	"time"
+)
+
+// Trade is a single trade as returned by the trades endpoint
+type Trade struct {
+	Timestamp  time.Time `json:"t"`
+	Exchange   string    `json:"x"`
+	Price      float64   `json:"p"`
+	Size       uint32    `json:"s"`
+	Conditions []string  `json:"c"`
+	ID         int64     `json:"i"`
+	Tape       string    `json:"z"`
+}
+
+// tradeKind fetches trades from the multi-symbol trades endpoint
+var tradeKind = dataKind[Trade]{
+	endpoint:       "trades",
+	columns:        tradeColumns,
+	defaultColumns: defaultTradeColumns,
+	schema:         tradeSchema,
+	appendRow:      appendTrade,
+}
+
+// tradeColumns are the CSV columns available for trades
+var tradeColumns = map[string]csvColumn[Trade]{
+	"timestamp":  func(tr Trade, style string) string { return formatTimestamp(tr.Timestamp, style) },
+	"exchange":   func(tr Trade, _ string) string { return tr.Exchange },
+	"price":      func(tr Trade, _ string) string { return formatFloat(tr.Price) },
+	"size":       func(tr Trade, _ string) string { return strconv.FormatUint(uint64(tr.Size), 10) },
+	"conditions": func(tr Trade, _ string) string { return formatConditions(tr.Conditions) },
+	"id":         func(tr Trade, _ string) string { return strconv.FormatInt(tr.ID, 10) },
+	"tape":       func(tr Trade, _ string) string { return tr.Tape },
+}
+
+// defaultTradeColumns is the column order used when none is configured
+var defaultTradeColumns = []string{"timestamp", "exchange", "price", "size", "conditions", "id", "tape"}
+
+// tradeSchema is the Arrow schema trades are written with
+var tradeSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "timestamp", Type: &arrow.TimestampType{Unit: arrow.Nanosecond, TimeZone: "UTC"}},
+	{Name: "exchange", Type: arrow.BinaryTypes.String},
+	{Name: "price", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "size", Type: arrow.PrimitiveTypes.Uint32},
+	{Name: "conditions", Type: arrow.ListOf(arrow.BinaryTypes.String)},
+	{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "tape", Type: arrow.BinaryTypes.String},
+}, nil)
+
+// appendTrade adds one trade as a row of tradeSchema
+func appendTrade(b *array.RecordBuilder, tr Trade) {
+	b.Field(0).(*array.TimestampBuilder).Append(arrow.Timestamp(tr.Timestamp.UnixNano()))
+	b.Field(1).(*array.StringBuilder).Append(tr.Exchange)
+	b.Field(2).(*array.Float64Builder).Append(tr.Price)
+	b.Field(3).(*array.Uint32Builder).Append(tr.Size)
+	appendStrings(b.Field(4).(*array.ListBuilder), tr.Conditions)
+	b.Field(5).(*array.Int64Builder).Append(tr.ID)
+	b.Field(6).(*array.StringBuilder).Append(tr.Tape)
+}
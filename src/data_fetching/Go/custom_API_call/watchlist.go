@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// watchlistAsset is the part of a watchlist asset the fetcher cares about
+type watchlistAsset struct {
+	Symbol string `json:"symbol"`
+}
+
+// watchlist is an Alpaca watchlist as returned by the trading API
+type watchlist struct {
+	ID     string           `json:"id"`
+	Name   string           `json:"name"`
+	Assets []watchlistAsset `json:"assets"`
+}
+
+// symbols lists the symbols of the watchlist in order
+func (w watchlist) symbols() []string {
+	symbols := make([]string, 0, len(w.Assets))
+	for _, a := range w.Assets {
+		symbols = append(symbols, a.Symbol)
+	}
+	return symbols
+}
+
+// WatchlistCache is the local copy of the account's watchlists, keyed by name
+type WatchlistCache struct {
+	SyncedAt   time.Time           `json:"synced_at"`
+	Watchlists map[string][]string `json:"watchlists"`
+}
+
+// getWatchlists lists the account's watchlists. The list endpoint omits assets.
+func (c *Client) getWatchlists(ctx context.Context) ([]watchlist, error) {
+	body, err := c.makeRequestURL(ctx, "GET", c.TradingURL+"/v2/watchlists")
+	if err != nil {
+		return nil, err
+	}
+
+	var lists []watchlist
+	if err := json.Unmarshal([]byte(body), &lists); err != nil {
+		return nil, fmt.Errorf("error decoding watchlists: %w", err)
+	}
+	return lists, nil
+}
+
+// getWatchlist fetches a single watchlist with its assets by ID
+func (c *Client) getWatchlist(ctx context.Context, id string) (watchlist, error) {
+	return c.decodeWatchlist(ctx, c.TradingURL+"/v2/watchlists/"+url.PathEscape(id))
+}
+
+// getWatchlistByName fetches a single watchlist with its assets by name
+func (c *Client) getWatchlistByName(ctx context.Context, name string) (watchlist, error) {
+	return c.decodeWatchlist(ctx, c.TradingURL+"/v2/watchlists:by_name?name="+url.QueryEscape(name))
+}
+
+func (c *Client) decodeWatchlist(ctx context.Context, u string) (watchlist, error) {
+	var w watchlist
+	body, err := c.makeRequestURL(ctx, "GET", u)
+	if err != nil {
+		return w, err
+	}
+	if err := json.Unmarshal([]byte(body), &w); err != nil {
+		return w, fmt.Errorf("error decoding watchlist: %w", err)
+	}
+	return w, nil
+}
+
+// syncWatchlists downloads every watchlist of the account into a fresh cache
+func (c *Client) syncWatchlists(ctx context.Context) (*WatchlistCache, error) {
+	lists, err := c.getWatchlists(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &WatchlistCache{SyncedAt: time.Now().UTC(), Watchlists: make(map[string][]string, len(lists))}
+	for _, l := range lists {
+		w, err := c.getWatchlist(ctx, l.ID)
+		if err != nil {
+			return nil, fmt.Errorf("watchlist %s: %w", l.Name, err)
+		}
+		cache.Watchlists[w.Name] = w.symbols()
+	}
+	return cache, nil
+}
+
+// loadWatchlistCache reads the cache, returning an empty one if it was never synced
+func loadWatchlistCache(path string) (*WatchlistCache, error) {
+	cache := &WatchlistCache{Watchlists: map[string][]string{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading watchlist cache: %w", err)
+	}
+	if err := json.Unmarshal(data, cache); err != nil {
+		return nil, fmt.Errorf("error parsing watchlist cache %s: %w", path, err)
+	}
+	return cache, nil
+}
+
+// save writes the cache to path
+func (cache *WatchlistCache) save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating watchlist cache directory: %w", err)
+	}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling watchlist cache: %w", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// watchlistSymbols returns the symbols of the named watchlist.
+// The synced cache is used first; a watchlist missing from it is fetched live.
+func watchlistSymbols(ctx context.Context, client *Client, cfg *Config, name string) ([]string, error) {
+	cache, err := loadWatchlistCache(cfg.WatchlistCache)
+	if err != nil {
+		return nil, err
+	}
+	if listed, ok := cache.Watchlists[name]; ok {
+		return listed, nil
+	}
+
+	w, err := client.getWatchlistByName(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("watchlist %s not synced and could not be fetched: %w", name, err)
+	}
+	return w.symbols(), nil
+}
+
+// runWatchlist handles the watchlist subcommands
+func runWatchlist(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("watchlist requires a subcommand (sync or show)")
+	}
+
+	fs := flag.NewFlagSet("watchlist "+args[0], flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "fetch config file")
+	fs.Parse(args[1:])
+
+	cfg, err := loadConfigOrDefault(*configPath)
+	if err != nil {
+		return err
+	}
+
+	var cache *WatchlistCache
+	switch args[0] {
+	case "sync":
+		client := NewClient()
+		if cfg.RateLimit > 0 {
+			client.RateLimiter = NewRateLimiter(cfg.RateLimit)
+		}
+		cache, err = client.syncWatchlists(context.Background())
+		if err != nil {
+			return err
+		}
+		if err := cache.save(cfg.WatchlistCache); err != nil {
+			return err
+		}
+		fmt.Printf("Synced %d watchlists to %s\n", len(cache.Watchlists), cfg.WatchlistCache)
+	case "show":
+		cache, err = loadWatchlistCache(cfg.WatchlistCache)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Watchlists synced at %s\n", cache.SyncedAt.Format(time.RFC3339))
+	default:
+		return fmt.Errorf("unknown watchlist subcommand %q (expected sync or show)", args[0])
+	}
+
+	names := make([]string, 0, len(cache.Watchlists))
+	for name := range cache.Watchlists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %s: %d symbols\n", name, len(cache.Watchlists[name]))
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"github.com/alpacahq/alpaca-trade-api-go/v3/marketdata"
@@ -63,6 +64,93 @@ func fetchMarketBars(client *marketdata.Client, symbol string, timeframe marketd
 
 }
 
+const (
+	// pageBars is the page size the data API uses for bars
+	pageBars = 10000
+	// maxWindow caps windows of coarse timeframes, which would otherwise overflow
+	maxWindow = 20 * 365 * 24 * time.Hour
+)
+
+// windowFor returns a time window that holds about one page of bars of the timeframe
+func windowFor(timeframe marketdata.TimeFrame) time.Duration {
+	unit := time.Minute
+	switch timeframe.Unit {
+	case marketdata.Hour:
+		unit = time.Hour
+	case marketdata.Day:
+		unit = 24 * time.Hour
+	case marketdata.Week:
+		unit = 7 * 24 * time.Hour
+	case marketdata.Month:
+		unit = 31 * 24 * time.Hour
+	}
+	if time.Duration(timeframe.N)*pageBars > maxWindow/unit {
+		return maxWindow
+	}
+	return time.Duration(timeframe.N) * unit * pageBars
+}
+
+// Stream bars for one stock window by window so long ranges are never held in memory.
+// The SDK follows next_page_token within each window.
+func streamMarketBars(client *marketdata.Client, symbol string, timeframe marketdata.TimeFrame, start_time time.Time, end_time time.Time, write func([]marketdata.Bar) error) error {
+	window := windowFor(timeframe)
+	for from := start_time; from.Before(end_time); from = from.Add(window) {
+		// End is inclusive, so stop just short of the next window
+		to := from.Add(window).Add(-time.Nanosecond)
+		if !to.Before(end_time) {
+			to = end_time
+		}
+
+		bars, err := client.GetBars(symbol, marketdata.GetBarsRequest{
+			TimeFrame: timeframe,
+			Start:     from,
+			End:       to,
+		})
+		if err != nil {
+			return fmt.Errorf("fetching bars from %s: %w", from.Format(time.RFC3339), err)
+		}
+		if err := write(bars); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stream bars for one stock into a JSON array file and return the number of bars written
+func streamBarsToJSON(client *marketdata.Client, symbol string, timeframe marketdata.TimeFrame, start_time time.Time, end_time time.Time, location string) (int, error) {
+	file, err := os.Create(location)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	out := bufio.NewWriter(file)
+	out.WriteByte('[')
+	count := 0
+	err = streamMarketBars(client, symbol, timeframe, start_time, end_time, func(bars []marketdata.Bar) error {
+		for _, bar := range bars {
+			barJSON, err := json.Marshal(bar)
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				out.WriteByte(',')
+			}
+			out.Write(barJSON)
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		// Do not leave a truncated array behind
+		file.Close()
+		os.Remove(location)
+		return 0, err
+	}
+	out.WriteByte(']')
+	return count, out.Flush()
+}
+
 func writeBarsToJSON(bars []marketdata.Bar, location string) {
 	// Marshal the bars into JSON
 	barsJSON, err := json.Marshal(bars)
@@ -99,11 +187,14 @@ func TestFetchAndWriteBars() {
 		// Starts from 2016, 1, 1
 		start_date := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
 
-		bars := fetchMarketBars(client, "NVDA", marketdata.OneDay, start_date, end_date)
+		// Stream the bars into a JSON file page by page
+		count, err := streamBarsToJSON(client, "NVDA", marketdata.OneDay, start_date, end_date, fmt.Sprintf("./data/bars%d.json", successfulCalls))
+		if err != nil {
+			log.Printf("Error streaming bars: %v", err)
+		}
 
-		// If bars were fetched successfully, write them to a JSON file
-		if len(bars) > 0 {
-			writeBarsToJSON(bars, fmt.Sprintf("./data/bars%d.json", successfulCalls))
+		// Only count calls that produced bars
+		if err == nil && count > 0 {
 			successfulCalls++
 		}
 